@@ -0,0 +1,43 @@
+package gateway
+
+import (
+	"sort"
+)
+
+// shed starts dropping queued messages, see shedLargestQueues. It does nothing
+// while shedding is already under way.
+func (g *Gateway) shed() {
+	if g.shedding.CAS(false, true) {
+		go g.shedLargestQueues()
+	}
+}
+
+// shedLargestQueues drops the queued data messages of the connections with the
+// largest queues until the memory budget is no longer exhausted.
+func (g *Gateway) shedLargestQueues() {
+	defer g.shedding.Store(false)
+
+	type queue struct {
+		c    *Connection
+		size int
+	}
+
+	var queues []queue
+	for _, c := range g.Connections() {
+		if size := c.queuedBytes(); size > 0 {
+			queues = append(queues, queue{c: c, size: size})
+		}
+	}
+
+	sort.Slice(queues, func(i, j int) bool {
+		return queues[i].size > queues[j].size
+	})
+
+	for _, q := range queues {
+		if !g.Budget.Exhausted() {
+			return
+		}
+
+		q.c.shedQueue()
+	}
+}
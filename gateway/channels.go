@@ -0,0 +1,179 @@
+package gateway
+
+import (
+	"hash/fnv"
+	"sync"
+)
+
+const (
+	channelShards = 64
+
+	// subscriberChunkSize bounds the subscribers copied by a single change
+	subscriberChunkSize = 256
+)
+
+// channelIndex maps channels to their subscribers. It is sharded by channel
+// hash and every channel keeps its subscribers grouped by fanout worker, in
+// chunks of up to subscriberChunkSize. Subscribing and unsubscribing copy the
+// chunks they change rather than modifying them, so a publish only takes the
+// current groups under a read lock and fans out without holding any lock,
+// while a change costs the same no matter how many subscribers there are.
+type channelIndex struct {
+	groups int
+	shards [channelShards]channelShard
+}
+
+type channelShard struct {
+	mu       sync.RWMutex
+	channels map[string]*subscriberSet
+}
+
+// subscriberSet holds the subscribers of a channel. Neither the groups nor
+// their chunks are modified once published, a change replaces what it
+// affects.
+type subscriberSet struct {
+	members map[*Connection]subscriberPos
+	groups  [][][]*Connection
+}
+
+// subscriberPos locates a subscriber in its group.
+type subscriberPos struct {
+	chunk, index int
+}
+
+// newChannelIndex returns an index that groups subscribers into the given
+// number of fanout groups.
+func newChannelIndex(groups int) *channelIndex {
+	idx := &channelIndex{
+		groups: groups,
+	}
+
+	for i := range idx.shards {
+		idx.shards[i].channels = map[string]*subscriberSet{}
+	}
+
+	return idx
+}
+
+func (idx *channelIndex) shard(channel string) *channelShard {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(channel))
+	return &idx.shards[h.Sum32()%channelShards]
+}
+
+// subscribers returns the current subscribers of channel as chunks grouped by
+// their fanout worker. The returned slices must not be modified.
+func (idx *channelIndex) subscribers(channel string) [][][]*Connection {
+	shard := idx.shard(channel)
+
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+
+	if set := shard.channels[channel]; set != nil {
+		return set.groups
+	}
+
+	return nil
+}
+
+func (idx *channelIndex) add(channel string, c *Connection) {
+	shard := idx.shard(channel)
+
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	set := shard.channels[channel]
+	if set == nil {
+		set = &subscriberSet{
+			members: map[*Connection]subscriberPos{},
+			groups:  make([][][]*Connection, idx.groups),
+		}
+
+		shard.channels[channel] = set
+	}
+
+	if _, ok := set.members[c]; ok {
+		return
+	}
+
+	chunks := set.copyGroupUnsafe(c.fanoutGroup)
+
+	// append to the last chunk unless it is full
+	last := len(chunks) - 1
+	if last < 0 || len(chunks[last]) == subscriberChunkSize {
+		chunks = append(chunks, nil)
+		last++
+	}
+
+	chunk := make([]*Connection, len(chunks[last])+1)
+	copy(chunk, chunks[last])
+	chunk[len(chunk)-1] = c
+	chunks[last] = chunk
+
+	set.members[c] = subscriberPos{chunk: last, index: len(chunk) - 1}
+	set.groups[c.fanoutGroup] = chunks
+}
+
+func (idx *channelIndex) remove(channel string, c *Connection) {
+	shard := idx.shard(channel)
+
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	set := shard.channels[channel]
+	if set == nil {
+		return
+	}
+
+	pos, ok := set.members[c]
+	if !ok {
+		return
+	}
+
+	delete(set.members, c)
+
+	if len(set.members) == 0 {
+		delete(shard.channels, channel)
+		return
+	}
+
+	chunks := set.copyGroupUnsafe(c.fanoutGroup)
+
+	// the last subscriber of the group takes the place of c
+	last := len(chunks) - 1
+	moved := chunks[last][len(chunks[last])-1]
+
+	if moved != c {
+		chunk := make([]*Connection, len(chunks[pos.chunk]))
+		copy(chunk, chunks[pos.chunk])
+		chunk[pos.index] = moved
+		chunks[pos.chunk] = chunk
+
+		set.members[moved] = pos
+	}
+
+	if n := len(chunks[last]) - 1; n > 0 {
+		chunk := make([]*Connection, n)
+		copy(chunk, chunks[last])
+		chunks[last] = chunk
+	} else {
+		chunks[last] = nil
+		chunks = chunks[:last]
+	}
+
+	set.groups[c.fanoutGroup] = chunks
+}
+
+// copyGroupUnsafe replaces the published groups and the chunk list of group i
+// with copies and returns the copied chunk list, which may then be changed.
+func (s *subscriberSet) copyGroupUnsafe(i int) [][]*Connection {
+	groups := make([][][]*Connection, len(s.groups))
+	copy(groups, s.groups)
+	s.groups = groups
+
+	chunks := make([][]*Connection, len(groups[i]), len(groups[i])+1)
+	copy(chunks, groups[i])
+	groups[i] = chunks
+
+	return chunks
+}
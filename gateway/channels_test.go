@@ -0,0 +1,175 @@
+package gateway
+
+import (
+	"strconv"
+	"testing"
+)
+
+const testGroups = 4
+
+func newTestSubscribers(n int) []*Connection {
+	conns := make([]*Connection, n)
+	for i := range conns {
+		conns[i] = &Connection{fanoutGroup: i % testGroups}
+	}
+
+	return conns
+}
+
+func countSubscribers(groups [][][]*Connection) map[*Connection]int {
+	counts := map[*Connection]int{}
+	for i, chunks := range groups {
+		for _, chunk := range chunks {
+			if len(chunk) == 0 || len(chunk) > subscriberChunkSize {
+				panic("subscriber chunk of invalid size")
+			}
+
+			for _, c := range chunk {
+				if c.fanoutGroup != i {
+					panic("subscriber in the wrong group")
+				}
+
+				counts[c]++
+			}
+		}
+	}
+
+	return counts
+}
+
+func TestChannelIndex(t *testing.T) {
+	idx := newChannelIndex(testGroups)
+	conns := newTestSubscribers(10)
+
+	for _, c := range conns {
+		idx.add("a", c)
+		idx.add("a", c)
+	}
+
+	idx.add("b", conns[0])
+
+	before := idx.subscribers("a")
+
+	// remove the first, a middle and the last subscriber of a group
+	for _, i := range []int{0, 4, 8, 9} {
+		idx.remove("a", conns[i])
+		idx.remove("a", conns[i])
+	}
+
+	if counts := countSubscribers(before); len(counts) != len(conns) {
+		t.Fatalf("expected an earlier snapshot to keep %d subscribers, got %d", len(conns), len(counts))
+	}
+
+	counts := countSubscribers(idx.subscribers("a"))
+	for i, c := range conns {
+		want := 1
+		if i == 0 || i == 4 || i == 8 || i == 9 {
+			want = 0
+		}
+
+		if counts[c] != want {
+			t.Errorf("expected subscriber %d to be listed %d times, got %d", i, want, counts[c])
+		}
+	}
+
+	// the remaining subscribers can still be removed, which drops the channel
+	for _, i := range []int{1, 2, 3, 5, 6, 7} {
+		idx.remove("a", conns[i])
+	}
+
+	if subscribers := idx.subscribers("a"); subscribers != nil {
+		t.Fatalf("expected no subscribers, got %v", subscribers)
+	}
+
+	if counts := countSubscribers(idx.subscribers("b")); len(counts) != 1 || counts[conns[0]] != 1 {
+		t.Fatalf("expected channel b to be unaffected, got %v", counts)
+	}
+}
+
+func TestChannelIndexChunks(t *testing.T) {
+	idx := newChannelIndex(testGroups)
+	conns := newTestSubscribers(testGroups*subscriberChunkSize*2 + 1)
+
+	for _, c := range conns {
+		idx.add("a", c)
+	}
+
+	// remove every other subscriber, starting from the first chunks
+	removed := map[*Connection]bool{}
+	for i := 0; i < len(conns); i += 2 {
+		idx.remove("a", conns[i])
+		removed[conns[i]] = true
+	}
+
+	counts := countSubscribers(idx.subscribers("a"))
+	if len(counts) != len(conns)-len(removed) {
+		t.Fatalf("expected %d subscribers, got %d", len(conns)-len(removed), len(counts))
+	}
+
+	for c := range counts {
+		if removed[c] {
+			t.Fatal("removed subscriber is still listed")
+		}
+	}
+
+	for i := 1; i < len(conns); i += 2 {
+		idx.remove("a", conns[i])
+	}
+
+	if subscribers := idx.subscribers("a"); subscribers != nil {
+		t.Fatalf("expected no subscribers, got %d groups", len(subscribers))
+	}
+}
+
+// BenchmarkSubscribeWhilePublishing measures subscribing to and
+// unsubscribing from a channel with many subscribers while it is being
+// published to in a loop.
+func BenchmarkSubscribeWhilePublishing(b *testing.B) {
+	for _, n := range []int{1000, 10000, 100000} {
+		b.Run(strconv.Itoa(n), func(b *testing.B) {
+			idx := newChannelIndex(fanoutWorkers)
+			for _, c := range newTestSubscribers(n) {
+				c.fanoutGroup %= fanoutWorkers
+				idx.add("bench", c)
+			}
+
+			done := make(chan struct{})
+			stopped := make(chan struct{})
+			go func() {
+				defer close(stopped)
+
+				for {
+					select {
+					case <-done:
+						return
+					default:
+					}
+
+					// walk the subscribers like the fanout workers do
+					for _, chunks := range idx.subscribers("bench") {
+						for _, chunk := range chunks {
+							for _, c := range chunk {
+								_ = c.fanoutGroup
+							}
+						}
+					}
+				}
+			}()
+
+			conns := newTestSubscribers(b.N)
+
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			for _, c := range conns {
+				idx.add("bench", c)
+				idx.remove("bench", c)
+			}
+
+			b.StopTimer()
+
+			close(done)
+			<-stopped
+		})
+	}
+}
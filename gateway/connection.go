@@ -1,13 +1,18 @@
 package gateway
 
 import (
+	"context"
 	"encoding/binary"
+	"errors"
 	"io"
-	"io/ioutil"
 	"log"
+	"strconv"
 	"sync"
+	stdatomic "sync/atomic"
 	"time"
 
+	"github.com/gobwas/pool/pbufio"
+	"github.com/gobwas/pool/pbytes"
 	"github.com/gobwas/ws"
 	"github.com/gobwas/ws/wsutil"
 	"github.com/google/uuid"
@@ -18,8 +23,9 @@ import (
 type Connection struct {
 	id uuid.UUID
 
-	gw   *Gateway
-	ctlr controller
+	gw    *Gateway
+	route *Route
+	ctlr  controller
 
 	mu sync.Mutex
 	rw io.ReadWriteCloser // connection
@@ -27,62 +33,170 @@ type Connection struct {
 	gc *grip.Connection
 
 	// outgoing messages
-	messages      []*wsutil.Message
+	messages      []*outgoingMessage
+	messagesBytes int
 	messagesMutex sync.RWMutex
+	dropCount     int
+	discarded     bool // nothing is queued once the queue has been discarded
 	transmitable  atomic.Bool
 
+	writeMutex    sync.Mutex
+	awaitWritable stdatomic.Value // func() error
+
 	// outgoing events
 	events        []grip.Event
+	eventsBytes   int
 	eventsMutex   sync.Mutex
+	eventsReady   chan uint64
+	eventsSince   time.Time // when the first queued event arrived
+	lingerGen     uint64
 	sendingEvents atomic.Bool
 
 	opened   atomic.Bool
 	closed   atomic.Bool
 	detached atomic.Bool
+	dropped  atomic.Bool
+
+	channelsMutex sync.Mutex
+	channels      map[string]struct{}
+	fanoutGroup   int
 
 	backendClosed atomic.Bool
 	clientClosed  atomic.Bool
 
-	keepAliveMutex        sync.RWMutex
-	keepAliveTimer        *time.Timer
-	keepAliveTimeout      time.Duration
-	keepAliveIntervalMode bool
-	keepAliveMessageType  ws.OpCode
-	keepAliveContent      []byte
+	// timersMutex guards the liveness, timeout and keep-alive timers and the
+	// state they act on
+	timersMutex sync.Mutex
+
+	livenessTimer   *wheelTimer
+	livenessPayload []byte
+	missedPongs     int
+	rtt             atomic.Duration
+
+	createdAt     time.Time
+	lastActivity  atomic.Int64
+	idleTimeout   time.Duration
+	idleTimer     *wheelTimer
+	lifetimeTimer *wheelTimer
+	closeTimer    *wheelTimer
+
+	keepAlive keepAliveState
+	lastWrite atomic.Int64
 
 	close func()
 }
 
 func (g *Gateway) NewConnection(path string, conn io.ReadWriteCloser, onclose func()) *Connection {
 	id := uuid.New()
+	route := g.route(path)
 	c := &Connection{
-		id: id,
+		id:    id,
+		gw:    g,
+		route: route,
 		ctlr: controller{
 			messagePrefix: defaultMessagePrefix,
 		},
-		rw:    conn,
-		fr:    wsutil.NewReader(conn, ws.StateServerSide),
-		gc:    g.t.NewConnection(path, id.String()),
-		close: onclose,
+		rw:          conn,
+		fr:          wsutil.NewReader(conn, ws.StateServerSide),
+		gc:          route.Transport.NewConnection(path, id.String()),
+		eventsReady: make(chan uint64, 1),
+		channels:    map[string]struct{}{},
+		fanoutGroup: g.fanout.group(id),
+		close:       onclose,
 	}
 
-	g.connections[c.id] = c
+	c.transmitable.Store(true)
+
+	g.register(c)
 
 	c.enqueueOutgoingEvents(grip.OpenEvent)
+	c.startLiveness()
+	c.startTimeouts()
 
 	return c
 }
 
+// SetWriteNotifier sets the function that is called when messages are queued
+// and no write is under way. It should arrange for ScheduleTransmit to be
+// called once the connection is writable. Without one, writes are scheduled
+// right away.
+func (c *Connection) SetWriteNotifier(awaitWritable func() error) {
+	c.awaitWritable.Store(awaitWritable)
+}
+
+// Transmit writes as many queued messages as fit in a write buffer to the
+// client. The gateway's WriteTimeout applies to the whole write. When
+// messages remain afterwards, the next write is awaited again.
 func (c *Connection) Transmit() error {
-	defer c.maybeResetKeepAliveTimer()
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+
+	messages := c.nextOutgoingMessages(writeBufferSize)
+	if len(messages) > 0 {
+		err := c.writeMessages(messages)
+		for _, message := range messages {
+			message.release()
+		}
 
-	opc, payload, ok := c.nextOutgoingMessage()
-	if !ok {
-		c.transmitable.Store(true)
+		if err != nil {
+			return err
+		}
+
+		c.lastWrite.Store(time.Now().UnixNano())
+	}
+
+	if c.doneTransmitting() {
 		return nil
 	}
 
-	return wsutil.WriteServerMessage(c.rw, opc, payload)
+	return c.awaitTransmit()
+}
+
+func (c *Connection) writeMessages(messages []*outgoingMessage) error {
+	if timeout := c.gw.WriteTimeout; timeout > 0 {
+		if conn, ok := c.rw.(interface{ SetWriteDeadline(time.Time) error }); ok {
+			if err := conn.SetWriteDeadline(time.Now().Add(timeout)); err != nil {
+				return err
+			}
+		}
+	}
+
+	bw := pbufio.GetWriter(c.rw, writeBufferSize)
+	defer pbufio.PutWriter(bw)
+
+	for _, message := range messages {
+		if err := message.writeTo(bw); err != nil {
+			return err
+		}
+	}
+
+	return bw.Flush()
+}
+
+// doneTransmitting reports whether the queue is drained, in which case the
+// next queued message starts transmitting again.
+func (c *Connection) doneTransmitting() bool {
+	c.messagesMutex.Lock()
+	defer c.messagesMutex.Unlock()
+
+	if len(c.messages) > 0 {
+		return false
+	}
+
+	c.transmitable.Store(true)
+
+	return true
+}
+
+// awaitTransmit arranges for the next write once the connection is writable.
+func (c *Connection) awaitTransmit() error {
+	if awaitWritable, ok := c.awaitWritable.Load().(func() error); ok && awaitWritable != nil {
+		return awaitWritable()
+	}
+
+	c.ScheduleTransmit()
+
+	return nil
 }
 
 func (c *Connection) Receive() error {
@@ -91,28 +205,31 @@ func (c *Connection) Receive() error {
 		return err
 	}
 
-	payload, err := ioutil.ReadAll(c.fr)
+	payload, err := c.readPayload(h)
 	if err != nil {
 		return err
 	}
 
+	// payload is put back here unless it is handed on
 	switch h.OpCode {
-	case ws.OpText:
-		c.enqueueOutgoingEvents(grip.NewTextEvent(string(payload)))
-
-	case ws.OpBinary:
-		c.enqueueOutgoingEvents(grip.NewBinaryEvent(payload))
+	case ws.OpText, ws.OpBinary:
+		// the event owns payload until it has been sent to the backend
+		c.touch()
+		c.enqueueOutgoingEvents(grip.NewPooledEvent(dataEventType(h.OpCode), payload))
 
 	case ws.OpClose:
 		var code uint16
+		var reason string
 		if len(payload) > 1 {
 			code = binary.BigEndian.Uint16(payload)
-			payload = payload[2:]
+			reason = string(payload[2:])
 		}
 
+		pbytes.Put(payload)
+
 		c.enqueueOutgoingEvents(grip.CloseEvent{
 			Code:   code,
-			Reason: string(payload),
+			Reason: reason,
 		})
 
 		c.clientClosed.Store(true)
@@ -123,21 +240,93 @@ func (c *Connection) Receive() error {
 		}
 
 	case ws.OpPing:
-		c.enqueueOutgoingEvents(grip.PingEvent)
+		if c.route.PingPolicy != ForwardPings {
+			c.enqueue(newPooledMessage(ws.OpPong, payload, payload))
+		} else {
+			pbytes.Put(payload)
+		}
+
+		if c.route.PingPolicy != AnswerPingsLocally {
+			c.enqueueOutgoingEvents(grip.PingEvent)
+		}
 
 	case ws.OpPong:
-		c.enqueueOutgoingEvents(grip.PongEvent)
+		ours := c.handleLivenessPong(payload)
+		pbytes.Put(payload)
+
+		if ours {
+			return nil
+		}
+
+		if c.route.PingPolicy != AnswerPingsLocally {
+			c.enqueueOutgoingEvents(grip.PongEvent)
+		}
+
+	default:
+		pbytes.Put(payload)
 	}
 
 	return nil
 }
 
+// readPayload reads the rest of the current message into a buffer from
+// pbytes. The frame length only serves as a hint, so that a client can't make
+// the gateway allocate more than it actually sends.
+func (c *Connection) readPayload(h ws.Header) ([]byte, error) {
+	size := int(h.Length)
+	if h.Length > maxPayloadHint {
+		size = maxPayloadHint
+	}
+
+	buf := pbytes.GetCap(size)
+	for {
+		if len(buf) == cap(buf) {
+			grown := pbytes.GetCap(2*cap(buf) + minPayloadGrowth)
+			grown = append(grown, buf...)
+			pbytes.Put(buf)
+			buf = grown
+		}
+
+		n, err := c.fr.Read(buf[len(buf):cap(buf)])
+		buf = buf[:len(buf)+n]
+
+		if err == io.EOF {
+			return buf, nil
+		} else if err != nil {
+			pbytes.Put(buf)
+			return nil, err
+		}
+	}
+}
+
+func (c *Connection) ID() uuid.UUID {
+	return c.id
+}
+
+// Drop closes the connection and removes it from the gateway. The backend is
+// sent a DISCONNECT event unless the connection was already closed. Calling
+// Drop more than once has no effect.
 func (c *Connection) Drop() error {
+	if !c.dropped.CAS(false, true) {
+		return nil
+	}
+
+	// stop waiting on responses the backend holds open, the DISCONNECT
+	// request is made afterwards and not cancelled
+	c.gc.Close()
+
 	if !c.closed.Load() {
 		c.closed.Store(true)
 		c.enqueueOutgoingEvents(grip.DisconnectEvent)
 	}
 
+	c.gw.unregister(c)
+	c.discardQueue()
+
+	c.stopLiveness()
+	c.stopTimeouts()
+	c.stopKeepAlive()
+
 	if c.close != nil {
 		c.close()
 	}
@@ -145,53 +334,291 @@ func (c *Connection) Drop() error {
 	return c.rw.Close()
 }
 
-func (c *Connection) handleIncomingEvents(events []grip.Event) error {
-	c.mu.Lock()
-	defer c.mu.Unlock()
+// nextOutgoingMessages takes queued messages up to size bytes of payload, but
+// always at least one.
+func (c *Connection) nextOutgoingMessages(size int) []*outgoingMessage {
+	c.messagesMutex.Lock()
+	defer c.messagesMutex.Unlock()
 
-	for _, event := range events {
-		if err := c.handleIncomingEventUnsafe(event); err != nil {
-			return err
+	var n, total int
+	for n < len(c.messages) {
+		next := len(c.messages[n].payload)
+		if n > 0 && total+next > size {
+			break
 		}
+
+		n++
+		total += next
 	}
 
-	return nil
+	if n == 0 {
+		return nil
+	}
+
+	messages := make([]*outgoingMessage, n)
+	copy(messages, c.messages)
+
+	for i := 0; i < n; i++ {
+		c.unholdUnsafe(c.messages[i])
+		c.messages[i] = nil
+	}
+
+	c.messages = c.messages[n:]
+
+	return messages
+}
+
+func (c *Connection) enqueueOutgoingMessage(opc ws.OpCode, payload []byte) {
+	c.enqueue(newOutgoingMessage(opc, payload))
 }
 
-func (c *Connection) isOutgoingMessageQueueEmpty() bool {
+// enqueueClose queues the close frame that starts the close handshake from
+// the backend's side. It returns false if one has been queued already.
+func (c *Connection) enqueueClose(payload []byte) bool {
+	if c.backendClosed.Load() {
+		return false
+	}
+
+	message := newOutgoingMessage(ws.OpClose, payload)
+
+	c.messagesMutex.Lock()
+
+	if c.backendClosed.Load() || c.discarded {
+		c.messagesMutex.Unlock()
+		message.release()
+		return false
+	}
+
+	c.backendClosed.Store(true)
+	exhausted := c.appendUnsafe(message)
+	c.messagesMutex.Unlock()
+
+	c.startTransmitting()
+	if exhausted {
+		c.gw.shed()
+	}
+
+	return true
+}
+
+// enqueue queues a message for the client. Nothing is queued once the close
+// frame has been or the connection has been dropped. Data messages that would overflow the route's queue limits
+// are handled according to its slow consumer policy, control frames are
+// always queued.
+func (c *Connection) enqueue(message *outgoingMessage) {
+	c.messagesMutex.Lock()
+
+	if c.backendClosed.Load() || c.discarded {
+		c.messagesMutex.Unlock()
+		message.release()
+		return
+	}
+
+	if c.conflateUnsafe(message) {
+		c.messagesMutex.Unlock()
+		return
+	}
+
+	if message.isData() && c.route.isQueueFull(len(c.messages)+1, c.messagesBytes+len(message.payload)) {
+		switch c.route.SlowConsumerPolicy {
+		case DropNewest:
+			dropped := c.countDropUnsafe(1)
+			c.messagesMutex.Unlock()
+
+			message.release()
+
+			c.reportDrops(dropped)
+			return
+
+		case DropOldest:
+			var n int
+			for c.route.isQueueFull(len(c.messages)+1, c.messagesBytes+len(message.payload)) && c.dropOldestDataUnsafe() {
+				n++
+			}
+
+			dropped := c.countDropUnsafe(n)
+			exhausted := c.appendUnsafe(message)
+			c.messagesMutex.Unlock()
+
+			c.reportDrops(dropped)
+			c.startTransmitting()
+			if exhausted {
+				c.gw.shed()
+			}
+			return
+
+		case Disconnect:
+			// the client is not keeping up, so there is no point in making
+			// the close frame wait behind the data still queued
+			c.dropDataUnsafe()
+			c.messagesMutex.Unlock()
+
+			message.release()
+
+			c.closeFromGateway(closeCodePolicyViolation, "slow consumer")
+			return
+		}
+	}
+
+	exhausted := c.appendUnsafe(message)
+	c.messagesMutex.Unlock()
+
+	c.startTransmitting()
+	if exhausted {
+		c.gw.shed()
+	}
+}
+
+// appendUnsafe queues message and reports whether the gateway's memory budget
+// is exhausted.
+func (c *Connection) appendUnsafe(message *outgoingMessage) bool {
+	c.messages = append(c.messages, message)
+	return c.holdUnsafe(message)
+}
+
+// startTransmitting awaits the next write unless one is already under way.
+func (c *Connection) startTransmitting() {
+	if !c.transmitable.CAS(true, false) {
+		return
+	}
+
+	if err := c.awaitTransmit(); err != nil {
+		log.Println("# failed to await write:", err)
+		c.Drop()
+	}
+}
+
+// holdUnsafe adds a queued message to the queued bytes of the connection and
+// to the gateway's memory budget. It reports whether the budget is exhausted.
+func (c *Connection) holdUnsafe(message *outgoingMessage) bool {
+	c.messagesBytes += len(message.payload)
+	return message.hold(c.gw.Budget)
+}
+
+// unholdUnsafe takes a message that left the queue out of the queued bytes
+// and the memory budget again.
+func (c *Connection) unholdUnsafe(message *outgoingMessage) {
+	c.messagesBytes -= len(message.payload)
+	message.unhold(c.gw.Budget)
+}
+
+// queuedBytes returns the payload size of the queued messages.
+func (c *Connection) queuedBytes() int {
 	c.messagesMutex.RLock()
 	defer c.messagesMutex.RUnlock()
 
-	return len(c.messages) == 0
+	return c.messagesBytes
 }
 
-func (c *Connection) nextOutgoingMessage() (_ ws.OpCode, _ []byte, ok bool) {
+// shedQueue drops all queued data messages.
+func (c *Connection) shedQueue() {
 	c.messagesMutex.Lock()
-	defer c.messagesMutex.Unlock()
 
-	for len(c.messages) == 0 {
-		return 0, nil, false
+	var dropped int
+	n := c.dropDataUnsafe()
+	if n > 0 {
+		dropped = c.countDropUnsafe(n)
 	}
 
-	message := c.messages[0]
-	c.messages[0] = nil
-	c.messages = c.messages[1:]
+	c.messagesMutex.Unlock()
 
-	return message.OpCode, message.Payload, true
+	if n > 0 {
+		c.reportDrops(dropped)
+	}
 }
 
-func (c *Connection) enqueueOutgoingMessage(opc ws.OpCode, payload []byte) {
+// dropDataUnsafe removes every queued data message and returns how many there
+// were. Control frames stay queued.
+func (c *Connection) dropDataUnsafe() int {
+	kept := c.messages[:0]
+	var n int
+	for _, message := range c.messages {
+		if message.isData() {
+			n++
+			c.unholdUnsafe(message)
+			message.release()
+			continue
+		}
+
+		kept = append(kept, message)
+	}
+
+	for i := len(kept); i < len(c.messages); i++ {
+		c.messages[i] = nil
+	}
+
+	c.messages = kept
+
+	return n
+}
+
+// discardQueue releases everything still queued once the connection is gone
+// and makes later messages be released rather than queued.
+func (c *Connection) discardQueue() {
 	c.messagesMutex.Lock()
 	defer c.messagesMutex.Unlock()
 
-	c.messages = append(c.messages, &wsutil.Message{
-		OpCode:  opc,
-		Payload: payload,
-	})
+	c.discarded = true
 
-	if c.transmitable.CAS(true, false) {
-		go c.Transmit()
+	for _, message := range c.messages {
+		c.unholdUnsafe(message)
+		message.release()
 	}
+
+	c.messages = nil
+}
+
+// conflateUnsafe replaces a still queued message that has the same
+// conflation key as message, so a client that falls behind only receives the
+// latest one.
+func (c *Connection) conflateUnsafe(message *outgoingMessage) bool {
+	if message.conflateKey == "" {
+		return false
+	}
+
+	for i, queued := range c.messages {
+		if queued.conflates(message) {
+			c.holdUnsafe(message)
+			c.unholdUnsafe(queued)
+			c.messages[i] = message
+			queued.release()
+			return true
+		}
+	}
+
+	return false
+}
+
+// dropOldestDataUnsafe removes the oldest queued data message. Control frames
+// are kept.
+func (c *Connection) dropOldestDataUnsafe() bool {
+	for i, message := range c.messages {
+		if !message.isData() {
+			continue
+		}
+
+		copy(c.messages[i:], c.messages[i+1:])
+		c.messages[len(c.messages)-1] = nil
+		c.messages = c.messages[:len(c.messages)-1]
+		c.unholdUnsafe(message)
+		message.release()
+
+		return true
+	}
+
+	return false
+}
+
+func (c *Connection) countDropUnsafe(n int) int {
+	c.dropCount += n
+	return c.dropCount
+}
+
+// reportDrops lets the backend know how many messages have been dropped so
+// far through the Dropped-Messages meta field, which is sent with the next
+// request.
+func (c *Connection) reportDrops(total int) {
+	c.gc.SetMeta(droppedMessagesMeta, strconv.Itoa(total))
 }
 
 func (c *Connection) handleIncomingEvent(event grip.Event) error {
@@ -203,6 +630,7 @@ func (c *Connection) handleIncomingEvent(event grip.Event) error {
 
 func (c *Connection) handleIncomingEventUnsafe(event grip.Event) error {
 	if c.closed.Load() {
+		grip.ReleaseEvent(event)
 		return nil
 	}
 
@@ -211,6 +639,7 @@ func (c *Connection) handleIncomingEventUnsafe(event grip.Event) error {
 	if !c.opened.Load() {
 		if event != grip.OpenEvent {
 			log.Println("# first event must be OPEN but got", event.Type())
+			grip.ReleaseEvent(event)
 		}
 
 		c.opened.Store(true)
@@ -238,9 +667,7 @@ func (c *Connection) handleIncomingEventUnsafe(event grip.Event) error {
 
 	switch event := event.(type) {
 	case grip.CloseEvent:
-		c.enqueueOutgoingMessage(ws.OpClose, event.Content())
-
-		c.backendClosed.Store(true)
+		c.enqueueClose(event.Content())
 
 		if c.clientClosed.Load() {
 			c.closed.Store(true)
@@ -256,99 +683,264 @@ func (c *Connection) handleIncomingEventUnsafe(event grip.Event) error {
 				c.handleControlMessage(ctrl)
 			}
 		} else if payload, ok := c.ctlr.isMessage(content); ok {
-			c.publishDataToClient(event.Type(), payload)
+			// the message takes over the content
+			c.publishDataToClient(event, payload)
+			return nil
 		}
 
+		grip.ReleaseEvent(event)
 		return nil
 	}
 
 	panic("unreachable")
 }
 
-func (c *Connection) publishDataToClient(mode string, payload []byte) {
-	switch mode {
-	case "BINARY":
-		c.enqueueOutgoingMessage(ws.OpBinary, payload)
+// publishDataToClient queues payload, which is part of the content of
+// event, for the client. The message takes over the content if it is pooled.
+func (c *Connection) publishDataToClient(event grip.DataEvent, payload []byte) {
+	opc, ok := dataOpCode(event.Type())
+	if !ok {
+		grip.ReleaseEvent(event)
+		return
+	}
 
-	case "TEXT":
-		c.enqueueOutgoingMessage(ws.OpText, payload)
+	var buf []byte
+	if event.Pooled() {
+		buf = event.Bytes()
 	}
+
+	c.enqueue(newPooledMessage(opc, payload, buf))
 }
 
 func (c *Connection) enqueueOutgoingEvents(events ...grip.Event) {
 	if c.detached.Load() {
+		releaseEvents(events)
 		return
 	}
 
 	c.eventsMutex.Lock()
-	defer c.eventsMutex.Unlock()
 
 	for _, event := range events {
 		log.Println("SEND:", event.Type())
 	}
 
+	if len(c.events) == 0 {
+		c.eventsSince = time.Now()
+	}
+
 	c.events = append(c.events, events...)
+	for _, event := range events {
+		c.eventsBytes += len(event.Content())
+		c.gw.Budget.Add(len(event.Content()))
+	}
+
+	if c.route.isBatchFull(len(c.events), c.eventsBytes) {
+		c.flushEvents()
+	}
 
-	if c.sendingEvents.CAS(false, true) {
-		c.sendingEvents.Store(true)
+	start := c.sendingEvents.CAS(false, true)
+	c.eventsMutex.Unlock()
 
-		go c.sendEventsToBackendLoop()
+	if start {
+		c.gw.scheduleBackend(c.sendEventsToBackendLoop)
 	}
 }
 
+// nextEventBatch takes as many queued events as the route allows in a single
+// request. The send loop is marked as stopped when there are none left, so
+// that the next enqueued event starts a new one.
 func (c *Connection) nextEventBatch() []grip.Event {
 	c.eventsMutex.Lock()
 	defer c.eventsMutex.Unlock()
 
-	events := c.events
-	c.events = nil
+	if len(c.events) == 0 {
+		c.sendingEvents.Store(false)
+		return nil
+	}
+
+	maxEvents, maxBytes := c.route.MaxEventBatch, c.route.MaxEventBatchBytes
+
+	var n, size int
+	for n < len(c.events) {
+		next := len(c.events[n].Content())
+		if n > 0 && maxBytes > 0 && size+next > maxBytes {
+			break
+		}
+
+		n++
+		size += next
+
+		if maxEvents > 0 && n >= maxEvents {
+			break
+		}
+	}
+
+	events := c.events[:n:n]
+	c.events = c.events[n:]
+	c.eventsBytes -= size
+
+	if len(c.events) == 0 {
+		c.events = nil
+	}
+
 	return events
 }
 
-func (c *Connection) sendEventsToBackendLoop() {
-	defer c.sendingEvents.Store(false)
+// flushEvents ends the current linger period. The token carries the linger's
+// generation, so one left over from an earlier linger can't end the next one
+// early. It must be called with eventsMutex held.
+func (c *Connection) flushEvents() {
+	for {
+		select {
+		case c.eventsReady <- c.lingerGen:
+			return
+		default:
+		}
+
+		// replace the stale token nobody waited for
+		select {
+		case <-c.eventsReady:
+		default:
+		}
+	}
+}
+
+// lingerForEvents lets more events accumulate until the route's linger time
+// has passed since the first queued event arrived, or until the batch is
+// full. It doesn't wait when no events are queued.
+func (c *Connection) lingerForEvents() {
+	if c.route.EventLinger <= 0 {
+		return
+	}
+
+	c.eventsMutex.Lock()
+	linger := c.route.EventLinger - time.Since(c.eventsSince)
+	if len(c.events) == 0 || linger <= 0 || c.route.isBatchFull(len(c.events), c.eventsBytes) {
+		c.eventsMutex.Unlock()
+		return
+	}
+
+	c.lingerGen++
+	gen := c.lingerGen
+	c.eventsMutex.Unlock()
+
+	timer := c.gw.timers.AfterFunc(linger, func() {
+		c.eventsMutex.Lock()
+		defer c.eventsMutex.Unlock()
+
+		if c.lingerGen == gen {
+			c.flushEvents()
+		}
+	})
+	defer timer.Stop()
 
 	for {
+		select {
+		case ready := <-c.eventsReady:
+			if ready == gen {
+				return
+			}
+		case <-c.gw.timers.Done():
+			// the timer is never going to fire
+			return
+		}
+	}
+}
+
+func (c *Connection) sendEventsToBackendLoop() {
+	for {
+		c.lingerForEvents()
+
 		events := c.nextEventBatch()
 		if len(events) == 0 {
 			return
 		}
 
-		if err := c.sendEventsToBackend(events); err != nil {
+		err := c.sendEventsToBackend(events)
+		c.gw.Budget.Release(eventsSize(events))
+		releaseEvents(events)
+
+		if err == nil {
+			continue
+		}
+
+		if !errors.Is(err, context.Canceled) {
 			log.Println("# failed to send events to backend:", err)
-			return
 		}
+
+		if c.dropped.Load() {
+			// the request may have been cancelled by Drop, which leaves the
+			// DISCONNECT to be sent
+			continue
+		}
+
+		c.eventsMutex.Lock()
+		c.sendingEvents.Store(false)
+		c.eventsMutex.Unlock()
+
+		return
 	}
 }
 
-func (c *Connection) sendEventsToBackend(events []grip.Event) error {
-	_, events, err := c.gc.SendEvents(events...)
-	if err != nil {
-		return err
+// releaseEvents puts back the pooled content of events for the backend once
+// they are done with, see grip.ReleaseEvent.
+func releaseEvents(events []grip.Event) {
+	for _, event := range events {
+		grip.ReleaseEvent(event)
 	}
+}
 
-	return c.handleIncomingEvents(events)
+func eventsSize(events []grip.Event) int {
+	var size int
+	for _, event := range events {
+		size += len(event.Content())
+	}
+
+	return size
 }
 
-func (c *Connection) subscribe(channel string) {
-	ch, ok := c.gw.channels[channel]
-	if !ok {
-		ch = make(map[*Connection]interface{})
-		c.gw.channels[channel] = ch
+// sendEventsToBackend applies each event in the backend's response as soon as
+// it arrives. When the backend may hold responses open, it returns once the
+// response has started, so the events queued in the meantime are sent in a
+// new request while the backend keeps pushing events over the earlier one.
+func (c *Connection) sendEventsToBackend(events []grip.Event) error {
+	handle := c.handleIncomingEvent
+	if c.route.PingPolicy == AnswerPings {
+		handle = c.swallowPingReplies(events)
 	}
 
-	ch[c] = nil
+	_, err := c.gc.StartEvents(handle, c.endResponse, events...)
+	return err
 }
 
-func (c *Connection) unsubscribe(channel string) {
-	ch, ok := c.gw.channels[channel]
-	if !ok {
-		return
+// swallowPingReplies returns a handler for the response to events that drops
+// a PING or PONG event for every PING among events. The gateway has answered
+// those pings itself already, so the backend's replies are not passed on.
+func (c *Connection) swallowPingReplies(events []grip.Event) func(grip.Event) error {
+	var pings int
+	for _, event := range events {
+		if event == grip.PingEvent {
+			pings++
+		}
+	}
+
+	if pings == 0 {
+		return c.handleIncomingEvent
 	}
 
-	delete(ch, c)
+	return func(event grip.Event) error {
+		if pings > 0 && (event == grip.PingEvent || event == grip.PongEvent) {
+			pings--
+			return nil
+		}
+
+		return c.handleIncomingEvent(event)
+	}
+}
 
-	if len(ch) == 0 {
-		delete(c.gw.channels, channel)
+// endResponse is called once a response the backend held open has ended.
+func (c *Connection) endResponse(err error) {
+	if err != nil && !errors.Is(err, context.Canceled) {
+		log.Println("# backend response failed:", err)
 	}
 }
@@ -0,0 +1,285 @@
+package gateway
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gobwas/ws"
+	"github.com/ssttevee/go-wsproxy/grip"
+)
+
+// frameConn is a client that sends the same frame over and over and
+// swallows writes.
+type frameConn struct {
+	frame []byte
+	off   int
+}
+
+func (f *frameConn) Read(p []byte) (int, error) {
+	n := copy(p, f.frame[f.off:])
+	f.off = (f.off + n) % len(f.frame)
+	return n, nil
+}
+
+func (f *frameConn) Write(p []byte) (int, error) { return len(p), nil }
+func (f *frameConn) Close() error                { return nil }
+
+func BenchmarkConnectionReceive(b *testing.B) {
+	for _, size := range []int{16, 1 << 10, 16 << 10} {
+		b.Run(strconv.Itoa(size), func(b *testing.B) {
+			frame := ws.MustCompileFrame(ws.MaskFrameInPlace(ws.NewBinaryFrame(make([]byte, size))))
+
+			g := newTestGateway(b)
+			c := g.NewConnection("/", &frameConn{frame: frame}, nil)
+
+			// events are released rather than sent to the origin, so only
+			// reading them is measured
+			c.detached.Store(true)
+
+			b.SetBytes(int64(size))
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				if err := c.Receive(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// TestDropWhileResponseOpen checks that a client's events reach an origin
+// that holds the response to OPEN open, and that dropping the client cancels
+// the held response and still sends DISCONNECT.
+func TestDropWhileResponseOpen(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+
+	received := make(chan string, 16)
+	cancelled := make(chan struct{}, 1)
+
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", grip.ContentTypeEvents)
+
+		var open bool
+		for it := grip.NewEventIterator(r.Body); ; {
+			event, err := it.Next()
+			if err != nil {
+				break
+			}
+
+			received <- event.Type()
+			open = open || event.Type() == grip.EventTypeOpen
+		}
+
+		if open {
+			_ = grip.WriteEvent(w, grip.OpenEvent)
+			w.(http.Flusher).Flush()
+
+			<-r.Context().Done()
+			cancelled <- struct{}{}
+		}
+	}))
+
+	defer origin.Close()
+
+	transport, err := grip.NewHTTPTransport(origin.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	transport.Streaming = true
+
+	g := New(transport)
+	defer g.Close()
+
+	frame := ws.MustCompileFrame(ws.MaskFrameInPlace(ws.NewTextFrame([]byte("hello"))))
+	c := g.NewConnection("/", &frameConn{frame: frame}, nil)
+
+	expect := func(typ string) {
+		t.Helper()
+
+		select {
+		case got := <-received:
+			if got != typ {
+				t.Fatalf("expected the origin to receive %s, got %s", typ, got)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for the origin to receive %s", typ)
+		}
+	}
+
+	expect(grip.EventTypeOpen)
+
+	if err := c.Receive(); err != nil {
+		t.Fatal(err)
+	}
+
+	expect(grip.EventTypeText)
+
+	if err := c.Drop(); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-cancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("held response was not cancelled")
+	}
+
+	expect(grip.EventTypeDisconnection)
+}
+
+// recordConn is a client that sends the given frames once and records the
+// frames written to it.
+type recordConn struct {
+	r io.Reader
+
+	mu      sync.Mutex
+	written bytes.Buffer
+}
+
+func (rc *recordConn) Read(p []byte) (int, error) { return rc.r.Read(p) }
+func (rc *recordConn) Close() error               { return nil }
+
+func (rc *recordConn) Write(p []byte) (int, error) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	return rc.written.Write(p)
+}
+
+// opCodes returns the op codes of the frames written so far.
+func (rc *recordConn) opCodes() []ws.OpCode {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	var ops []ws.OpCode
+	for r := bytes.NewReader(rc.written.Bytes()); ; {
+		frame, err := ws.ReadFrame(r)
+		if err != nil {
+			return ops
+		}
+
+		ops = append(ops, frame.Header.OpCode)
+	}
+}
+
+// TestAnswerPings checks that only the reply to a forwarded ping is
+// swallowed, and a ping the origin sends on its own still reaches the client.
+func TestAnswerPings(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+
+	received := make(chan string, 16)
+
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", grip.ContentTypeEvents)
+
+		for it := grip.NewEventIterator(r.Body); ; {
+			event, err := it.Next()
+			if err != nil {
+				return
+			}
+
+			received <- event.Type()
+
+			// the origin never answers pings, but pings the client when it
+			// says something
+			switch event.Type() {
+			case grip.EventTypeOpen:
+				_ = grip.WriteEvent(w, grip.OpenEvent)
+			case grip.EventTypeText:
+				_ = grip.WriteEvent(w, grip.PingEvent)
+			}
+		}
+	}))
+
+	defer origin.Close()
+
+	transport, err := grip.NewHTTPTransport(origin.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	g := New(transport)
+	defer g.Close()
+
+	g.defaultRoute.PingPolicy = AnswerPings
+
+	var frames bytes.Buffer
+	_ = ws.WriteFrame(&frames, ws.MaskFrameInPlace(ws.NewPingFrame(nil)))
+	_ = ws.WriteFrame(&frames, ws.MaskFrameInPlace(ws.NewTextFrame([]byte("hello"))))
+
+	conn := &recordConn{r: &frames}
+	c := g.NewConnection("/", conn, nil)
+
+	// the text goes in a request of its own, a ping the origin sends in
+	// response to a request that carried a ping can't be told from a reply
+	for _, typ := range []string{grip.EventTypePing, grip.EventTypeText} {
+		if err := c.Receive(); err != nil {
+			t.Fatal(err)
+		}
+
+		for got := ""; got != typ; {
+			select {
+			case got = <-received:
+			case <-time.After(5 * time.Second):
+				t.Fatalf("timed out waiting for the origin to receive %s", typ)
+			}
+		}
+	}
+
+	want := []ws.OpCode{ws.OpPong, ws.OpPing}
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		ops := conn.opCodes()
+		if len(ops) == len(want) && ops[0] == want[0] && ops[1] == want[1] {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the client to receive %v, got %v", want, ops)
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// TestEnqueueAfterDrop checks that messages for a dropped connection are
+// released rather than queued and held against the memory budget.
+func TestEnqueueAfterDrop(t *testing.T) {
+	g := newTestGateway(t)
+	c := g.NewConnection("/", discardConn{}, nil)
+
+	c.Drop()
+
+	for deadline := time.Now().Add(5 * time.Second); c.sendingEvents.Load(); {
+		if time.Now().After(deadline) {
+			t.Fatal("send loop didn't finish")
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+
+	c.enqueueOutgoingMessage(ws.OpText, []byte("hello"))
+	c.enqueueOutgoingMessage(ws.OpPing, nil)
+
+	if c.enqueueClose(ws.NewCloseFrameBody(ws.StatusNormalClosure, "")) {
+		t.Fatal("expected no close frame to be queued after Drop")
+	}
+
+	if n := len(c.messages); n != 0 {
+		t.Fatalf("expected no queued messages, got %d", n)
+	}
+
+	if used := g.Budget.Used(); used != 0 {
+		t.Fatalf("expected the budget to be released, %d bytes are held", used)
+	}
+}
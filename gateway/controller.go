@@ -15,9 +15,12 @@ type controlMessage struct {
 	Channel       *string `json:"channel"`
 	Content       *string `json:"content"`
 	BinaryContent []byte  `json:"content-bin"`
+	ContentMeta   *string `json:"content-meta"`
 	MessageType   *string `json:"message-type"`
 	Timeout       *int    `json:"timeout"`
 	Mode          *string `json:"mode"`
+	IdleTimeout   *int    `json:"idle-timeout"`
+	MaxLifetime   *int    `json:"max-lifetime"`
 }
 
 type controller struct {
@@ -53,15 +56,17 @@ func (c *Connection) handleControlMessage(ctrl *controlMessage) {
 	switch ctrl.Type {
 	case "subscribe":
 		if ctrl.Channel != nil && *ctrl.Channel != "" {
-			c.subscribe(*ctrl.Channel)
+			c.gw.subscribe(c, *ctrl.Channel)
 		}
 	case "unsubscribe":
 		if ctrl.Channel != nil && *ctrl.Channel != "" {
-			c.unsubscribe(*ctrl.Channel)
+			c.gw.unsubscribe(c, *ctrl.Channel)
 		}
 	case "detach":
 		c.detached.Store(true)
 	case "keep-alive":
 		c.handleKeepAliveControlMessage(ctrl)
+	case "timeouts":
+		c.handleTimeoutsControlMessage(ctrl)
 	}
 }
@@ -0,0 +1,59 @@
+package gateway
+
+import (
+	"encoding/binary"
+
+	"github.com/google/uuid"
+)
+
+// fanout delivers published messages to subscribers on a fixed set of
+// workers. A connection is always served by the same worker, picked from its
+// id, and every worker handles its queue in order, so each connection queues
+// the messages published to it in the order they were published.
+type fanout struct {
+	queues []chan func()
+}
+
+func newFanout(workers, queue int) *fanout {
+	f := &fanout{
+		queues: make([]chan func(), workers),
+	}
+
+	for i := range f.queues {
+		f.queues[i] = make(chan func(), queue)
+		go f.worker(f.queues[i])
+	}
+
+	return f
+}
+
+func (f *fanout) worker(queue chan func()) {
+	for task := range queue {
+		task()
+	}
+}
+
+// group returns the worker that serves the connection with the given id.
+func (f *fanout) group(id uuid.UUID) int {
+	return int(binary.BigEndian.Uint32(id[:4]) % uint32(len(f.queues)))
+}
+
+// publish queues message for the subscribers, which are chunks grouped by
+// worker as returned by channelIndex.subscribers. It blocks while a worker's
+// queue is full.
+func (f *fanout) publish(subscribers [][][]*Connection, message *outgoingMessage) {
+	for i, chunks := range subscribers {
+		if len(chunks) == 0 {
+			continue
+		}
+
+		chunks := chunks
+		f.queues[i] <- func() {
+			for _, chunk := range chunks {
+				for _, c := range chunk {
+					c.enqueue(message)
+				}
+			}
+		}
+	}
+}
@@ -2,37 +2,143 @@ package gateway
 
 import (
 	"net/http"
+	"runtime"
 	"sync"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/ssttevee/go-wsproxy/grip"
+	gopool "github.com/ssttevee/go-wsproxy/pool"
+	"go.uber.org/atomic"
+)
+
+var (
+	fanoutWorkers = runtime.NumCPU() * 4
+	fanoutQueue   = 1024
+)
+
+const (
+	// DefaultWorkers and DefaultWorkerQueue size the pool that is used when
+	// Pool is not set.
+	DefaultWorkers     = 16384
+	DefaultWorkerQueue = 1024
+
+	// DefaultBackendWorkers and DefaultBackendQueue size the pool that is
+	// used when BackendPool is not set.
+	DefaultBackendWorkers = 16384
+	DefaultBackendQueue   = 1024
+
+	DefaultScheduleTimeout = 10 * time.Millisecond
 )
 
 type Gateway struct {
-	t grip.Transport
+	// Budget bounds the memory held in outgoing queues and pending events.
+	// While it is exhausted new connections are refused and the largest
+	// queues are shed. Transports may share it to account their buffers.
+	Budget *grip.Budget
+
+	// IOModel serves the connections passed to Accept. It defaults to
+	// GoroutineIO.
+	IOModel IOModel
+
+	// Pool runs the reads and writes of connections. It must be set before
+	// the first connection, a pool of DefaultWorkers is created when it is
+	// nil.
+	Pool     *gopool.Pool
+	poolOnce sync.Once
+
+	// BackendPool runs the send loops of connections to the backend, which
+	// hold a worker while they linger for events, wait for the origin's
+	// limiter or batch window and until the response arrives. Keeping them
+	// apart from Pool means slow origins never hold up client I/O. It must be
+	// set before the first connection, a pool of DefaultBackendWorkers is
+	// created when it is nil.
+	BackendPool     *gopool.Pool
+	backendPoolOnce sync.Once
+
+	// WriteTimeout bounds each write to a client. Zero means no limit.
+	WriteTimeout time.Duration
+
+	// ScheduleTimeout is how long work waits for a free worker of either pool
+	// before reads are rejected and writes and sends are retried later.
+	ScheduleTimeout time.Duration
+
+	defaultRoute *Route
+	routes       []*Route
+
+	timers *timerWheel
 
 	mu          sync.RWMutex
 	connections map[uuid.UUID]*Connection
-	channels    map[string]map[*Connection]interface{}
+	channels    *channelIndex
+
+	fanout *fanout
+
+	shedding atomic.Bool
 }
 
 func New(transport grip.Transport) *Gateway {
+	fanout := newFanout(fanoutWorkers, fanoutQueue)
+
 	return &Gateway{
-		t: transport,
+		defaultRoute: &Route{
+			Transport: transport,
+		},
+		timers:      newTimerWheel(defaultTimerTick),
+		connections: map[uuid.UUID]*Connection{},
+		channels:    newChannelIndex(len(fanout.queues)),
+		fanout:      fanout,
+
+		IOModel:         GoroutineIO{},
+		ScheduleTimeout: DefaultScheduleTimeout,
 	}
 }
 
+// Close stops the timers shared by the gateway's connections. Connections
+// are not closed, but their timeouts, keep-alives and liveness pings stop
+// firing.
+func (g *Gateway) Close() {
+	g.timers.Stop()
+}
+
+// Accepting reports whether new connections on path are accepted, which they
+// are not while the memory budget is exhausted or the route's origin is
+// saturated.
+func (g *Gateway) Accepting(path string) bool {
+	return !g.Budget.Exhausted() && !g.route(path).Transport.Saturated()
+}
+
 func (g *Gateway) Forward(w http.ResponseWriter, r *http.Request) {
-	g.t.ForwardRequest(w, r)
+	g.route(r.URL.Path).Transport.ForwardRequest(w, r)
 }
 
+// Publish queues content for every subscriber of channel. The frame is
+// encoded once and shared by all subscribers. Subscribers are handed to a
+// bounded set of workers, each connection always to the same one, so every
+// subscriber receives messages in publish order. Publish may block while the
+// workers are busy.
 func (g *Gateway) Publish(channel string, mode string, content []byte) {
-	ch, ok := g.channels[channel]
+	g.PublishConflated(channel, "", mode, content)
+}
+
+// PublishConflated is like Publish, but a message that is still queued for a
+// subscriber is replaced by a later one with the same conflation key. This
+// suits channels where only the latest value matters. An empty key disables
+// conflation.
+func (g *Gateway) PublishConflated(channel string, key string, mode string, content []byte) {
+	opc, ok := dataOpCode(mode)
 	if !ok {
 		return
 	}
 
-	for c := range ch {
-		go c.publishDataToClient(mode, content)
+	subscribers := g.channels.subscribers(channel)
+	if len(subscribers) == 0 {
+		return
 	}
+
+	message := newFramedMessage(opc, content)
+	message.channel = channel
+	message.conflateKey = key
+
+	g.fanout.publish(subscribers, message)
 }
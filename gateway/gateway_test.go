@@ -0,0 +1,140 @@
+package gateway
+
+import (
+	"io"
+	"io/ioutil"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ssttevee/go-wsproxy/grip"
+)
+
+// newTestGateway returns a gateway in front of an origin that answers
+// batched requests without any events. Other requests are answered like an
+// echo server would, OPEN with OPEN and TEXT with the same message.
+func newTestGateway(tb testing.TB) *Gateway {
+	log.SetOutput(ioutil.Discard)
+
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mediaType, params, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if mediaType != grip.ContentTypeBatch {
+			w.Header().Set("Content-Type", grip.ContentTypeEvents)
+
+			for it := grip.NewEventIterator(r.Body); ; {
+				event, err := it.Next()
+				if err != nil {
+					return
+				}
+
+				var reply grip.Event
+				switch event.Type() {
+				case grip.EventTypeOpen:
+					reply = grip.OpenEvent
+				case grip.EventTypeText:
+					reply = grip.NewTextEvent("m:" + string(event.Content()))
+				default:
+					continue
+				}
+
+				if err := grip.WriteEvent(w, reply); err != nil {
+					return
+				}
+			}
+		}
+
+		// the whole request is read before responding, since the server
+		// can't read the body once the response is under way
+		var ids []string
+		for mr := multipart.NewReader(r.Body, params["boundary"]); ; {
+			part, err := mr.NextPart()
+			if err != nil {
+				break
+			}
+
+			ids = append(ids, part.Header.Get("Connection-Id"))
+		}
+
+		mw := multipart.NewWriter(w)
+		w.Header().Set("Content-Type", mime.FormatMediaType(grip.ContentTypeBatch, map[string]string{
+			"boundary": mw.Boundary(),
+		}))
+
+		for _, id := range ids {
+			h := textproto.MIMEHeader{}
+			h.Set("Connection-Id", id)
+			h.Set("Content-Type", grip.ContentTypeEvents)
+			if _, err := mw.CreatePart(h); err != nil {
+				return
+			}
+		}
+
+		_ = mw.Close()
+	}))
+
+	tb.Cleanup(origin.Close)
+
+	transport, err := grip.NewHTTPTransport(origin.URL, nil)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	transport.Codec = grip.EventsCodec
+
+	g := New(transport)
+	tb.Cleanup(g.Close)
+
+	return g
+}
+
+// discardConn is a client that never sends anything and swallows writes.
+type discardConn struct{}
+
+func (discardConn) Read([]byte) (int, error)    { return 0, io.EOF }
+func (discardConn) Write(p []byte) (int, error) { return len(p), nil }
+func (discardConn) Close() error                { return nil }
+
+// waitFanout waits for the fanout workers to finish what is queued.
+func waitFanout(g *Gateway) {
+	var wg sync.WaitGroup
+	for _, queue := range g.fanout.queues {
+		wg.Add(1)
+		queue <- wg.Done
+	}
+
+	wg.Wait()
+}
+
+func BenchmarkPublish(b *testing.B) {
+	for _, n := range []int{1000, 10000, 100000} {
+		b.Run(strconv.Itoa(n), func(b *testing.B) {
+			g := newTestGateway(b)
+			g.defaultRoute.MaxQueuedMessages = 64
+
+			// batch the OPEN requests of so many connections
+			g.defaultRoute.Transport.(*grip.HTTPTransport).BatchWindow = 10 * time.Millisecond
+
+			for i := 0; i < n; i++ {
+				g.subscribe(g.NewConnection("/", discardConn{}, nil), "bench")
+			}
+
+			content := []byte("hello")
+
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				g.Publish("bench", "TEXT", content)
+			}
+
+			waitFanout(g)
+		})
+	}
+}
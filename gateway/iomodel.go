@@ -0,0 +1,86 @@
+package gateway
+
+import (
+	"log"
+	"net"
+)
+
+// IOModel drives the reads and writes of client connections. Every model
+// shares the same Connection, they only differ in how they wait for a
+// connection to become readable or writable.
+type IOModel interface {
+	// Serve creates the gateway connection for conn with NewConnection and
+	// drives it until it is dropped. It returns without waiting for that.
+	Serve(g *Gateway, path string, conn net.Conn) (*Connection, error)
+}
+
+// Accept serves a client connection that has completed the websocket
+// handshake with the gateway's IOModel.
+func (g *Gateway) Accept(path string, conn net.Conn) (*Connection, error) {
+	return g.IOModel.Serve(g, path, conn)
+}
+
+// GoroutineIO serves each connection with a reader and a writer goroutine
+// that block on the connection. It is simple to reason about at the cost of
+// two goroutines per connection.
+type GoroutineIO struct{}
+
+func (GoroutineIO) Serve(g *Gateway, path string, conn net.Conn) (*Connection, error) {
+	done := make(chan struct{})
+	c := g.NewConnection(path, conn, func() {
+		log.Printf("# %s: dropped", nameConn(conn))
+		close(done)
+	})
+
+	writable := make(chan struct{}, 1)
+	c.SetWriteNotifier(func() error {
+		select {
+		case writable <- struct{}{}:
+		default:
+		}
+
+		return nil
+	})
+
+	go func() {
+		for {
+			// hold off reading while there is no memory to spare
+			select {
+			case <-g.Budget.Available():
+			case <-done:
+				return
+			}
+
+			if err := c.Receive(); err != nil {
+				if !c.dropped.Load() {
+					log.Println("# receive error:", err)
+				}
+
+				c.Drop()
+				return
+			}
+		}
+	}()
+
+	go func() {
+		for {
+			select {
+			case <-writable:
+			case <-done:
+				return
+			}
+
+			if err := c.Transmit(); err != nil {
+				log.Println("# transmit error:", err)
+				c.Drop()
+				return
+			}
+		}
+	}()
+
+	return c, nil
+}
+
+func nameConn(conn net.Conn) string {
+	return conn.LocalAddr().String() + " > " + conn.RemoteAddr().String()
+}
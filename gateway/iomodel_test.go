@@ -0,0 +1,178 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsutil"
+)
+
+// BenchmarkIOModel measures round trips of a text message from the client
+// through the origin and back, over loopback connections served by each I/O
+// model.
+func BenchmarkIOModel(b *testing.B) {
+	for _, model := range testIOModels(b) {
+		b.Run(model.name, func(b *testing.B) {
+			benchmarkIOModel(b, model.io)
+		})
+	}
+}
+
+// testIOModels returns every I/O model to test.
+func testIOModels(tb testing.TB) []struct {
+	name string
+	io   IOModel
+} {
+	netpoll, err := NewNetpollIO()
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	return []struct {
+		name string
+		io   IOModel
+	}{
+		{"goroutine", GoroutineIO{}},
+		{"netpoll", netpoll},
+	}
+}
+
+// listenTestGateway accepts websocket clients for g on a loopback address
+// and returns it.
+func listenTestGateway(tb testing.TB, g *Gateway) string {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	tb.Cleanup(func() { lis.Close() })
+
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				if _, err := ws.Upgrade(conn); err != nil {
+					conn.Close()
+					return
+				}
+
+				if _, err := g.Accept("/", conn); err != nil {
+					conn.Close()
+				}
+			}()
+		}
+	}()
+
+	return lis.Addr().String()
+}
+
+// TestIOModelFramesInOneWrite checks that every frame is read when a client
+// sends several in a single write.
+func TestIOModelFramesInOneWrite(t *testing.T) {
+	for _, model := range testIOModels(t) {
+		t.Run(model.name, func(t *testing.T) {
+			g := newTestGateway(t)
+			g.IOModel = model.io
+
+			conn, br, _, err := ws.Dial(context.Background(), "ws://"+listenTestGateway(t, g)+"/")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if br != nil {
+				ws.PutReader(br)
+			}
+
+			defer conn.Close()
+
+			var buf bytes.Buffer
+			for _, text := range []string{"one", "two"} {
+				if err := wsutil.WriteClientText(&buf, []byte(text)); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if _, err := conn.Write(buf.Bytes()); err != nil {
+				t.Fatal(err)
+			}
+
+			_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+			for _, want := range []string{"one", "two"} {
+				got, err := wsutil.ReadServerText(conn)
+				if err != nil {
+					t.Fatalf("waiting for %q: %v", want, err)
+				}
+
+				if string(got) != want {
+					t.Fatalf("expected %q, got %q", want, got)
+				}
+			}
+		})
+	}
+}
+
+func benchmarkIOModel(b *testing.B, model IOModel) {
+	const clients = 64
+
+	g := newTestGateway(b)
+	g.IOModel = model
+
+	addr := listenTestGateway(b, g)
+
+	conns := make([]net.Conn, clients)
+	for i := range conns {
+		conn, br, _, err := ws.Dial(context.Background(), "ws://"+addr+"/")
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		if br != nil {
+			ws.PutReader(br)
+		}
+
+		b.Cleanup(func() { conn.Close() })
+		conns[i] = conn
+	}
+
+	payload := []byte("hello")
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	var wg sync.WaitGroup
+	for i, conn := range conns {
+		n := b.N / clients
+		if i < b.N%clients {
+			n++
+		}
+
+		wg.Add(1)
+		go func(conn net.Conn, n int) {
+			defer wg.Done()
+
+			for j := 0; j < n; j++ {
+				if err := wsutil.WriteClientText(conn, payload); err != nil {
+					b.Error(err)
+					return
+				}
+
+				if _, err := wsutil.ReadServerText(conn); err != nil {
+					b.Error(err)
+					return
+				}
+			}
+		}(conn, n)
+	}
+
+	wg.Wait()
+}
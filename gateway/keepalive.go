@@ -6,70 +6,147 @@ import (
 	"github.com/gobwas/ws"
 )
 
+type keepAliveMode int
+
+const (
+	keepAliveDisabled keepAliveMode = iota
+
+	// keepAliveIdle sends the keep-alive message once nothing has been
+	// written to the client for the timeout.
+	keepAliveIdle
+
+	// keepAliveInterval sends the keep-alive message every timeout
+	// regardless of other traffic.
+	keepAliveInterval
+)
+
+type keepAliveState struct {
+	mode        keepAliveMode
+	timeout     time.Duration
+	opc         ws.OpCode
+	content     []byte
+	contentMeta string
+	timer       *wheelTimer
+}
+
+// handleKeepAliveControlMessage updates the keep-alive settings. Fields that
+// are left out keep their current value, so a message may change only the
+// content or only the timeout. A timeout of zero or less disables keep-alive.
 func (c *Connection) handleKeepAliveControlMessage(ctrl *controlMessage) {
-	if ctrl.Timeout == nil || *ctrl.Timeout <= 0 {
+	c.timersMutex.Lock()
+	defer c.timersMutex.Unlock()
+
+	ka := &c.keepAlive
+
+	if ctrl.Timeout != nil && *ctrl.Timeout <= 0 {
+		c.disableKeepAliveUnsafe()
 		return
 	}
 
-	c.keepAliveMutex.Lock()
-	defer c.keepAliveMutex.Unlock()
+	next := *ka
 
-	c.keepAliveTimeout = time.Duration(*ctrl.Timeout) * time.Second
-
-	if ctrl.MessageType == nil {
-		ctrl.MessageType = new(string)
+	if ctrl.Timeout != nil {
+		next.timeout = time.Duration(*ctrl.Timeout) * time.Second
 	}
 
-	switch *ctrl.MessageType {
-	case "binary":
-		c.keepAliveMessageType = ws.OpBinary
-		if len(ctrl.BinaryContent) == 0 {
-			return
+	if ctrl.MessageType != nil || next.mode == keepAliveDisabled {
+		var messageType string
+		if ctrl.MessageType != nil {
+			messageType = *ctrl.MessageType
 		}
 
-		c.keepAliveContent = ctrl.BinaryContent
-	case "ping":
-		c.keepAliveMessageType = ws.OpPing
-	case "pong":
-		c.keepAliveMessageType = ws.OpPong
-	case "":
-		c.keepAliveMessageType = ws.OpText
-		if ctrl.Content == nil || *ctrl.Content == "" {
+		switch messageType {
+		case "", "text":
+			next.opc = ws.OpText
+		case "binary":
+			next.opc = ws.OpBinary
+		case "ping":
+			next.opc = ws.OpPing
+		case "pong":
+			next.opc = ws.OpPong
+		default:
 			return
 		}
+	}
+
+	if ctrl.Content != nil {
+		next.content = []byte(*ctrl.Content)
+		next.contentMeta = ""
+	} else if len(ctrl.BinaryContent) > 0 {
+		next.content = ctrl.BinaryContent
+		next.contentMeta = ""
+	}
+
+	if ctrl.ContentMeta != nil {
+		next.contentMeta = *ctrl.ContentMeta
+	}
+
+	if ctrl.Mode != nil {
+		switch *ctrl.Mode {
+		case "interval":
+			next.mode = keepAliveInterval
+		default:
+			next.mode = keepAliveIdle
+		}
+	} else if next.mode == keepAliveDisabled {
+		next.mode = keepAliveIdle
+	}
 
-		c.keepAliveContent = []byte(*ctrl.Content)
-	default:
+	// data messages are pointless without content
+	if next.timeout <= 0 || ((next.opc == ws.OpText || next.opc == ws.OpBinary) && len(next.content) == 0 && next.contentMeta == "") {
 		return
 	}
 
-	if ctrl.Mode != nil && *ctrl.Mode == "interval" {
-		c.keepAliveIntervalMode = true
+	if next.timer != nil {
+		next.timer.Stop()
 	}
 
-	c.keepAliveTimer = time.AfterFunc(c.keepAliveTimeout, c.sendKeepAlive)
+	next.timer = c.gw.timers.AfterFunc(next.timeout, c.sendKeepAlive)
+
+	*ka = next
 }
 
-func (c *Connection) sendKeepAlive() {
-	c.keepAliveMutex.RLock()
-	defer c.keepAliveMutex.RUnlock()
+func (c *Connection) disableKeepAliveUnsafe() {
+	if c.keepAlive.timer != nil {
+		c.keepAlive.timer.Stop()
+	}
 
-	c.keepAliveTimer.Stop()
+	c.keepAlive = keepAliveState{}
+}
 
-	if c.keepAliveIntervalMode || c.isOutgoingMessageQueueEmpty() {
-		c.keepAliveTimer.Reset(c.keepAliveTimeout)
+func (c *Connection) stopKeepAlive() {
+	c.timersMutex.Lock()
+	defer c.timersMutex.Unlock()
 
-		c.enqueueOutgoingMessage(c.keepAliveMessageType, c.keepAliveContent)
-	}
+	c.disableKeepAliveUnsafe()
 }
 
-func (c *Connection) maybeResetKeepAliveTimer() {
-	c.keepAliveMutex.RLock()
-	defer c.keepAliveMutex.RUnlock()
+func (c *Connection) sendKeepAlive() {
+	c.timersMutex.Lock()
 
-	c.keepAliveTimer.Stop()
+	ka := &c.keepAlive
+	if ka.mode == keepAliveDisabled || c.closed.Load() {
+		c.timersMutex.Unlock()
+		return
+	}
+
+	if ka.mode == keepAliveIdle {
+		idle := time.Duration(time.Now().UnixNano() - c.lastWrite.Load())
+		if idle < ka.timeout {
+			ka.timer.Reset(ka.timeout - idle)
+			c.timersMutex.Unlock()
+			return
+		}
+	}
+
+	ka.timer.Reset(ka.timeout)
 
-	if c.keepAliveTimer != nil && c.keepAliveIntervalMode {
-		c.keepAliveTimer.Reset(c.keepAliveTimeout)
+	opc, content := ka.opc, ka.content
+	if ka.contentMeta != "" {
+		content = []byte(c.gc.Meta(ka.contentMeta))
 	}
+
+	c.timersMutex.Unlock()
+
+	c.enqueueOutgoingMessage(opc, content)
 }
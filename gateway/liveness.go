@@ -0,0 +1,94 @@
+package gateway
+
+import (
+	"bytes"
+	"encoding/binary"
+	"log"
+	"time"
+
+	"github.com/gobwas/ws"
+)
+
+// startLiveness starts pinging the client if the route asks for it.
+func (c *Connection) startLiveness() {
+	if c.route.PingInterval <= 0 {
+		return
+	}
+
+	c.timersMutex.Lock()
+	defer c.timersMutex.Unlock()
+
+	c.livenessTimer = c.gw.timers.AfterFunc(c.route.PingInterval, c.sendLivenessPing)
+}
+
+func (c *Connection) stopLiveness() {
+	c.timersMutex.Lock()
+	defer c.timersMutex.Unlock()
+
+	if c.livenessTimer != nil {
+		c.livenessTimer.Stop()
+	}
+}
+
+// sendLivenessPing pings the client and drops it once it has missed too many
+// pongs in a row.
+func (c *Connection) sendLivenessPing() {
+	if c.closed.Load() {
+		return
+	}
+
+	c.timersMutex.Lock()
+
+	if c.livenessPayload != nil {
+		c.missedPongs++
+
+		if max := c.route.MaxMissedPongs; max > 0 && c.missedPongs >= max {
+			c.timersMutex.Unlock()
+
+			log.Println("# client missed", c.missedPongs, "pongs")
+			c.Drop()
+			return
+		}
+	}
+
+	c.livenessPayload = make([]byte, 8)
+	binary.BigEndian.PutUint64(c.livenessPayload, uint64(time.Now().UnixNano()))
+	c.livenessTimer.Reset(c.route.PingInterval)
+
+	payload := c.livenessPayload
+
+	c.timersMutex.Unlock()
+
+	c.enqueueOutgoingMessage(ws.OpPing, payload)
+}
+
+// handleLivenessPong records a pong from the client. It returns true if the
+// pong answers a ping sent by the gateway, in which case it is not forwarded
+// to the origin.
+func (c *Connection) handleLivenessPong(payload []byte) bool {
+	c.timersMutex.Lock()
+	defer c.timersMutex.Unlock()
+
+	if c.livenessTimer == nil {
+		return false
+	}
+
+	// any pong shows the client is still around
+	c.missedPongs = 0
+
+	if c.livenessPayload == nil || !bytes.Equal(payload, c.livenessPayload) {
+		return false
+	}
+
+	sent := int64(binary.BigEndian.Uint64(c.livenessPayload))
+	c.rtt.Store(time.Duration(time.Now().UnixNano() - sent))
+	c.livenessPayload = nil
+
+	return true
+}
+
+// RTT returns the round trip time measured by the last answered liveness
+// ping, or zero if none has been answered yet.
+func (c *Connection) RTT() time.Duration {
+	return c.rtt.Load()
+}
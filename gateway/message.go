@@ -0,0 +1,149 @@
+package gateway
+
+import (
+	"io"
+
+	"github.com/gobwas/pool/pbytes"
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsutil"
+	"github.com/ssttevee/go-wsproxy/grip"
+	"go.uber.org/atomic"
+)
+
+// droppedMessagesMeta is the meta field that holds the number of messages
+// dropped because the client was not keeping up.
+const droppedMessagesMeta = "Dropped-Messages"
+
+// writeBufferSize is the size of the buffer messages are written through,
+// which also bounds how much is written per Transmit.
+const writeBufferSize = 16 << 10
+
+const (
+	// maxPayloadHint bounds the buffer allocated up front for a frame from
+	// its length, larger payloads grow the buffer as they arrive.
+	maxPayloadHint = 64 << 10
+
+	// minPayloadGrowth is added when growing a payload buffer, so that
+	// empty buffers grow too.
+	minPayloadGrowth = 512
+)
+
+// outgoingMessage is a message queued for a client. Messages are never
+// modified once queued, so a single message may be shared by many
+// connections.
+type outgoingMessage struct {
+	opc     ws.OpCode
+	payload []byte
+
+	// frame is the complete encoded frame when it was built up front
+	frame []byte
+
+	// buf is the pooled buffer payload was read into, if any. It is put
+	// back once the message has been written or dropped.
+	buf []byte
+
+	// channel and conflateKey identify messages that supersede each other
+	// while queued, conflateKey is empty for messages that do not
+	channel     string
+	conflateKey string
+
+	// holders counts the queues a framed message is in, see hold
+	holders atomic.Int32
+}
+
+func newOutgoingMessage(opc ws.OpCode, payload []byte) *outgoingMessage {
+	return &outgoingMessage{
+		opc:     opc,
+		payload: payload,
+	}
+}
+
+// newPooledMessage creates a message whose payload lives in buf, a buffer
+// from pbytes that the message takes ownership of.
+func newPooledMessage(opc ws.OpCode, payload []byte, buf []byte) *outgoingMessage {
+	return &outgoingMessage{
+		opc:     opc,
+		payload: payload,
+		buf:     buf,
+	}
+}
+
+// newFramedMessage builds the frame of a message once, for messages that are
+// sent to many clients.
+func newFramedMessage(opc ws.OpCode, payload []byte) *outgoingMessage {
+	return &outgoingMessage{
+		opc:     opc,
+		payload: payload,
+		frame:   ws.MustCompileFrame(ws.NewFrame(opc, true, payload)),
+	}
+}
+
+// isData reports whether the message is a text or binary message, as
+// opposed to a control frame.
+func (m *outgoingMessage) isData() bool {
+	return m.opc == ws.OpText || m.opc == ws.OpBinary
+}
+
+// conflates reports whether other replaces m when m is still queued.
+func (m *outgoingMessage) conflates(other *outgoingMessage) bool {
+	return m.conflateKey != "" && m.conflateKey == other.conflateKey && m.channel == other.channel
+}
+
+// hold accounts the message in budget when it is queued. A framed message
+// is shared by its subscribers, so it is only accounted by the first queue
+// it enters and given back by the last one it leaves.
+func (m *outgoingMessage) hold(budget *grip.Budget) bool {
+	if m.frame != nil && m.holders.Inc() > 1 {
+		return budget.Exhausted()
+	}
+
+	return budget.Add(len(m.payload))
+}
+
+// unhold gives back what hold accounted once the message leaves a queue.
+func (m *outgoingMessage) unhold(budget *grip.Budget) {
+	if m.frame != nil && m.holders.Dec() > 0 {
+		return
+	}
+
+	budget.Release(len(m.payload))
+}
+
+// release puts the pooled buffer of the message back once it has been
+// written or dropped.
+func (m *outgoingMessage) release() {
+	if m.buf != nil {
+		pbytes.Put(m.buf)
+		m.buf = nil
+	}
+}
+
+func (m *outgoingMessage) writeTo(w io.Writer) error {
+	if m.frame != nil {
+		_, err := w.Write(m.frame)
+		return err
+	}
+
+	return wsutil.WriteServerMessage(w, m.opc, m.payload)
+}
+
+// dataOpCode returns the op code of a TEXT or BINARY mode.
+func dataOpCode(mode string) (ws.OpCode, bool) {
+	switch mode {
+	case "BINARY":
+		return ws.OpBinary, true
+	case "TEXT":
+		return ws.OpText, true
+	}
+
+	return 0, false
+}
+
+// dataEventType returns the event type of a TEXT or BINARY op code.
+func dataEventType(opc ws.OpCode) string {
+	if opc == ws.OpBinary {
+		return grip.EventTypeBinary
+	}
+
+	return grip.EventTypeText
+}
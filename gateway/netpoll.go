@@ -0,0 +1,138 @@
+package gateway
+
+import (
+	"log"
+	"net"
+	"sync"
+
+	"github.com/mailru/easygo/netpoll"
+)
+
+// NetpollIO serves connections from a shared netpoll instance, so that idle
+// connections hold no goroutines. Reads and writes run on the gateway's Pool.
+type NetpollIO struct {
+	poller netpoll.Poller
+}
+
+// NewNetpollIO creates a netpoll instance to serve connections from.
+func NewNetpollIO() (*NetpollIO, error) {
+	poller, err := netpoll.New(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &NetpollIO{poller: poller}, nil
+}
+
+func (n *NetpollIO) Serve(g *Gateway, path string, conn net.Conn) (*Connection, error) {
+	// Reads are one shot, so a single frame is read at a time and the
+	// descriptor is resumed afterwards. It is level triggered, so frames
+	// that arrived together are reported again until all have been read.
+	// Write events are only of interest while messages are queued, so
+	// writes get their own one shot descriptor.
+	desc, err := netpoll.HandleReadOnce(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	writeDesc, err := netpoll.HandleWriteOnce(conn)
+	if err != nil {
+		desc.Close()
+		return nil, err
+	}
+
+	// mu keeps the descriptors from being closed while they are being set
+	// up or resumed, a closed descriptor's fd may already belong to another
+	// connection.
+	var mu sync.Mutex
+	var closed bool
+
+	mu.Lock()
+
+	c := g.NewConnection(path, conn, func() {
+		mu.Lock()
+		defer mu.Unlock()
+
+		log.Printf("# %s: dropped", nameConn(conn))
+		closed = true
+		n.poller.Stop(desc)
+		n.poller.Stop(writeDesc)
+		desc.Close()
+		writeDesc.Close()
+	})
+
+	resume := func(desc *netpoll.Desc) error {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if closed {
+			return nil
+		}
+
+		return n.poller.Resume(desc)
+	}
+
+	resumeRead := func() {
+		if err := resume(desc); err != nil {
+			log.Println("# failed to resume reading:", err)
+			c.Drop()
+		}
+	}
+
+	// The first write event flushes whatever was queued in the meantime,
+	// later ones are awaited after each write.
+	err = n.poller.Start(writeDesc, func(ev netpoll.Event) {
+		if ev&netpoll.EventWrite != 0 {
+			c.ScheduleTransmit()
+		}
+	})
+	if err != nil {
+		mu.Unlock()
+		c.Drop()
+		return nil, err
+	}
+
+	c.SetWriteNotifier(func() error {
+		return resume(writeDesc)
+	})
+
+	// reads start last, so a hang up can't close the descriptors before
+	// they are set up
+	err = n.poller.Start(desc, func(ev netpoll.Event) {
+		if ev&(netpoll.EventReadHup|netpoll.EventHup) != 0 {
+			// When ReadHup or Hup received, this mean that client has
+			// closed at least write end of the connection or connections
+			// itself. So we want to stop receive events about such conn
+			// and remove it from the registry.
+			c.Drop()
+			return
+		}
+
+		if ev&netpoll.EventRead == 0 {
+			resumeRead()
+			return
+		}
+
+		if g.Budget.Exhausted() {
+			// Stop reading until there is memory to spare. Resuming the
+			// descriptor reports data that arrived meanwhile.
+			g.Budget.Notify(resumeRead)
+			return
+		}
+
+		if c.ScheduleReceive(resumeRead) != nil {
+			// The pool is overloaded, so stop reading for a while rather
+			// than piling up reads.
+			g.timers.AfterFunc(g.ScheduleTimeout, resumeRead)
+		}
+	})
+
+	mu.Unlock()
+
+	if err != nil {
+		c.Drop()
+		return nil, err
+	}
+
+	return c, nil
+}
@@ -23,17 +23,47 @@ type controlItem struct {
 	ID      *string             `json:"id"`
 	Formats *controlItemFormats `json:"formats"`
 	Code    *uint16             `json:"code"`
+
+	// ConflateKey lets a later item with the same key replace this one while
+	// it is still queued for a subscriber.
+	ConflateKey string `json:"conflate-key"`
 }
 
 type envelopedControlItems struct {
 	Items []*controlItem `json:"items"`
 }
 
+// PublishHandler returns a handler that accepts publish requests in the form
+// {"items": [...]} and delivers the ws-message format of each item to the
+// subscribers of its channel.
+func (g *Gateway) PublishHandler() http.Handler {
+	return http.HandlerFunc(g.handlePublishRequest)
+}
+
 func (g *Gateway) handlePublishRequest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
 	var data envelopedControlItems
 	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
 		log.Println("failed to decode publish payload:", err)
+		http.Error(w, "invalid publish payload", http.StatusBadRequest)
 		return
 	}
 
+	for _, item := range data.Items {
+		if item == nil || item.Formats == nil || item.Formats.WSMessage == nil {
+			continue
+		}
+
+		if msg := item.Formats.WSMessage; msg.Content != nil {
+			g.PublishConflated(item.Channel, item.ConflateKey, "TEXT", []byte(*msg.Content))
+		} else if msg.ContentBin != nil {
+			g.PublishConflated(item.Channel, item.ConflateKey, "BINARY", msg.ContentBin)
+		}
+	}
+
+	w.Write([]byte("Published\n"))
 }
@@ -0,0 +1,79 @@
+package gateway
+
+import (
+	"github.com/google/uuid"
+)
+
+func (g *Gateway) register(c *Connection) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.connections[c.id] = c
+}
+
+// unregister removes the connection from the registry and from every channel
+// it is subscribed to.
+func (g *Gateway) unregister(c *Connection) {
+	g.mu.Lock()
+	delete(g.connections, c.id)
+	g.mu.Unlock()
+
+	c.channelsMutex.Lock()
+	defer c.channelsMutex.Unlock()
+
+	for channel := range c.channels {
+		g.channels.remove(channel, c)
+	}
+
+	c.channels = nil
+}
+
+// Connection returns the connection with the given id, or nil if there is no
+// such connection.
+func (g *Gateway) Connection(id uuid.UUID) *Connection {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	return g.connections[id]
+}
+
+// Connections returns a snapshot of all open connections.
+func (g *Gateway) Connections() []*Connection {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	connections := make([]*Connection, 0, len(g.connections))
+	for _, c := range g.connections {
+		connections = append(connections, c)
+	}
+
+	return connections
+}
+
+// Count returns the number of open connections.
+func (g *Gateway) Count() int {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	return len(g.connections)
+}
+
+func (g *Gateway) subscribe(c *Connection, channel string) {
+	c.channelsMutex.Lock()
+	defer c.channelsMutex.Unlock()
+
+	if c.dropped.Load() {
+		return
+	}
+
+	c.channels[channel] = struct{}{}
+	g.channels.add(channel, c)
+}
+
+func (g *Gateway) unsubscribe(c *Connection, channel string) {
+	c.channelsMutex.Lock()
+	defer c.channelsMutex.Unlock()
+
+	delete(c.channels, channel)
+	g.channels.remove(channel, c)
+}
@@ -0,0 +1,153 @@
+package gateway
+
+import (
+	"strings"
+	"time"
+
+	"github.com/ssttevee/go-wsproxy/grip"
+)
+
+// PingPolicy decides how client PING and PONG frames are handled.
+type PingPolicy int
+
+const (
+	// ForwardPings sends client PING and PONG frames to the origin as events
+	// and leaves it to the origin to answer pings.
+	ForwardPings PingPolicy = iota
+
+	// AnswerPings answers client pings with a pong from the gateway and still
+	// forwards PING and PONG frames to the origin. A PING or PONG event in the
+	// response to a request that carried a ping is taken as the origin's reply
+	// and not passed on to the client.
+	AnswerPings
+
+	// AnswerPingsLocally answers client pings with a pong from the gateway
+	// and does not forward PING and PONG frames to the origin.
+	AnswerPingsLocally
+)
+
+// ParsePingPolicy parses forward, answer or local into a PingPolicy.
+func ParsePingPolicy(s string) (PingPolicy, bool) {
+	switch s {
+	case "forward":
+		return ForwardPings, true
+	case "answer":
+		return AnswerPings, true
+	case "local":
+		return AnswerPingsLocally, true
+	}
+
+	return 0, false
+}
+
+// SlowConsumerPolicy decides what happens when a client's outgoing queue is
+// full.
+type SlowConsumerPolicy int
+
+const (
+	// DropOldest discards the oldest queued message to make room.
+	DropOldest SlowConsumerPolicy = iota
+
+	// DropNewest discards the message being queued.
+	DropNewest
+
+	// Disconnect closes the connection with 1008.
+	Disconnect
+)
+
+// ParseSlowConsumerPolicy parses drop-oldest, drop-newest or disconnect into
+// a SlowConsumerPolicy.
+func ParseSlowConsumerPolicy(s string) (SlowConsumerPolicy, bool) {
+	switch s {
+	case "drop-oldest":
+		return DropOldest, true
+	case "drop-newest":
+		return DropNewest, true
+	case "disconnect":
+		return Disconnect, true
+	}
+
+	return 0, false
+}
+
+// Route holds the settings of the connections and requests whose path starts
+// with Prefix. The longest matching prefix wins.
+type Route struct {
+	Prefix    string
+	Transport grip.Transport
+
+	// EventLinger is how long client events are collected before they are
+	// sent to the origin, unless the batch fills up first.
+	EventLinger time.Duration
+
+	// MaxEventBatch and MaxEventBatchBytes bound the number of events and
+	// their total content size sent to the origin in one request. Zero means
+	// no limit.
+	MaxEventBatch      int
+	MaxEventBatchBytes int
+
+	// PingPolicy decides whether client pings are answered by the gateway
+	// and whether PING and PONG frames reach the origin.
+	PingPolicy PingPolicy
+
+	// PingInterval is how often the gateway pings clients to check they are
+	// still alive. Zero disables liveness checks.
+	PingInterval time.Duration
+
+	// MaxMissedPongs is the number of consecutive pings a client may leave
+	// unanswered before it is dropped. Zero never drops clients.
+	MaxMissedPongs int
+
+	// IdleTimeout closes connections with 1000 once the client has not sent
+	// a data frame for this long. Zero disables it.
+	IdleTimeout time.Duration
+
+	// MaxLifetime closes connections with 1001 once they have been open for
+	// this long plus a random duration of up to LifetimeJitter, so that
+	// connections opened together do not all close together. Zero disables
+	// it.
+	MaxLifetime    time.Duration
+	LifetimeJitter time.Duration
+
+	// MaxQueuedMessages and MaxQueuedBytes bound the outgoing queue of each
+	// connection. Zero means no limit. SlowConsumerPolicy decides what
+	// happens to connections that exceed them.
+	MaxQueuedMessages  int
+	MaxQueuedBytes     int
+	SlowConsumerPolicy SlowConsumerPolicy
+}
+
+// isQueueFull reports whether a queue of n messages with size bytes of
+// payload exceeds the route's limits.
+func (r *Route) isQueueFull(n int, size int) bool {
+	return (r.MaxQueuedMessages > 0 && n > r.MaxQueuedMessages) || (r.MaxQueuedBytes > 0 && size > r.MaxQueuedBytes)
+}
+
+// AddRoute registers a route. A route with an empty prefix replaces the
+// default route. Routes must be added before the gateway starts handling
+// connections.
+func (g *Gateway) AddRoute(r *Route) {
+	if r.Prefix == "" {
+		g.defaultRoute = r
+		return
+	}
+
+	g.routes = append(g.routes, r)
+}
+
+// isBatchFull reports whether a batch of n events with size bytes of content
+// may not grow any further.
+func (r *Route) isBatchFull(n int, size int) bool {
+	return (r.MaxEventBatch > 0 && n >= r.MaxEventBatch) || (r.MaxEventBatchBytes > 0 && size >= r.MaxEventBatchBytes)
+}
+
+func (g *Gateway) route(path string) *Route {
+	match := g.defaultRoute
+	for _, r := range g.routes {
+		if strings.HasPrefix(path, r.Prefix) && len(r.Prefix) > len(match.Prefix) {
+			match = r
+		}
+	}
+
+	return match
+}
@@ -0,0 +1,87 @@
+package gateway
+
+import (
+	"log"
+
+	gopool "github.com/ssttevee/go-wsproxy/pool"
+)
+
+// pool returns the gateway's Pool, creating the default one on first use.
+func (g *Gateway) pool() *gopool.Pool {
+	g.poolOnce.Do(func() {
+		if g.Pool == nil {
+			g.Pool = gopool.NewPool(DefaultWorkers, DefaultWorkerQueue, 1)
+		}
+	})
+
+	return g.Pool
+}
+
+// backendPool returns the gateway's BackendPool, creating the default one on
+// first use.
+func (g *Gateway) backendPool() *gopool.Pool {
+	g.backendPoolOnce.Do(func() {
+		if g.BackendPool == nil {
+			g.BackendPool = gopool.NewPool(DefaultBackendWorkers, DefaultBackendQueue, 1)
+		}
+	})
+
+	return g.BackendPool
+}
+
+// schedule runs task on the pool, waiting up to ScheduleTimeout for a free
+// worker.
+func (g *Gateway) schedule(task func()) error {
+	return g.pool().ScheduleTimeout(g.ScheduleTimeout, task)
+}
+
+// scheduleOrDelay runs task on the pool, or later when the pool is
+// overloaded.
+func (g *Gateway) scheduleOrDelay(task func()) {
+	g.scheduleOnOrDelay(g.pool(), task)
+}
+
+// scheduleBackend runs task on the backend pool, or later when the pool is
+// overloaded.
+func (g *Gateway) scheduleBackend(task func()) {
+	g.scheduleOnOrDelay(g.backendPool(), task)
+}
+
+// scheduleOnOrDelay runs task on p. When no worker frees up in time,
+// scheduling is tried again after ScheduleTimeout rather than growing the
+// number of goroutines.
+func (g *Gateway) scheduleOnOrDelay(p *gopool.Pool, task func()) {
+	if err := p.ScheduleTimeout(g.ScheduleTimeout, task); err != nil {
+		g.timers.AfterFunc(g.ScheduleTimeout, func() {
+			g.scheduleOnOrDelay(p, task)
+		})
+	}
+}
+
+// ScheduleReceive reads the next frame from the client on the gateway's
+// pool and calls next once it has been read, or drops the connection when
+// reading fails. An error is returned when no worker frees up in time, the
+// caller should then hold off reading and try again later, so that a short
+// burst delays reads rather than dropping clients.
+func (c *Connection) ScheduleReceive(next func()) error {
+	return c.gw.schedule(func() {
+		if err := c.Receive(); err != nil {
+			log.Println("# receive error:", err)
+			c.Drop()
+			return
+		}
+
+		next()
+	})
+}
+
+// ScheduleTransmit writes the next queued message to the client on the
+// gateway's pool, or later when the pool is overloaded.
+func (c *Connection) ScheduleTransmit() {
+	c.gw.scheduleOrDelay(func() {
+		if err := c.Transmit(); err != nil {
+			log.Println("# transmit error:", err)
+			c.Drop()
+		}
+	})
+}
@@ -0,0 +1,37 @@
+package gateway
+
+import (
+	"testing"
+	"time"
+
+	gopool "github.com/ssttevee/go-wsproxy/pool"
+)
+
+// TestSendLoopKeepsPoolFree checks that a send loop lingering for events
+// doesn't hold a worker of the pool that serves client I/O.
+func TestSendLoopKeepsPoolFree(t *testing.T) {
+	g := newTestGateway(t)
+	g.defaultRoute.EventLinger = time.Hour
+	g.Pool = gopool.NewPool(1, 1, 1)
+
+	c := g.NewConnection("/", discardConn{}, nil)
+
+	for deadline := time.Now().Add(5 * time.Second); !c.sendingEvents.Load(); {
+		if time.Now().After(deadline) {
+			t.Fatal("send loop didn't start")
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+
+	ran := make(chan struct{})
+	if err := g.schedule(func() { close(ran) }); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-ran:
+	case <-time.After(5 * time.Second):
+		t.Fatal("i/o task waited for the lingering send loop")
+	}
+}
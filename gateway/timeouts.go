@@ -0,0 +1,150 @@
+package gateway
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/ssttevee/go-wsproxy/grip"
+)
+
+const (
+	closeCodeNormal    = 1000
+	closeCodeGoingAway = 1001
+
+	closeCodePolicyViolation = 1008
+
+	// closeTimeout is how long a client gets to answer a close frame sent by
+	// the gateway before it is dropped.
+	closeTimeout = 5 * time.Second
+)
+
+// startTimeouts arms the idle and lifetime timers of the route.
+func (c *Connection) startTimeouts() {
+	c.timersMutex.Lock()
+	defer c.timersMutex.Unlock()
+
+	c.createdAt = time.Now()
+	c.lastActivity.Store(c.createdAt.UnixNano())
+
+	c.setIdleTimeoutUnsafe(c.route.IdleTimeout)
+
+	if lifetime := c.route.MaxLifetime; lifetime > 0 {
+		if jitter := c.route.LifetimeJitter; jitter > 0 {
+			lifetime += time.Duration(rand.Int63n(int64(jitter)))
+		}
+
+		c.setMaxLifetimeUnsafe(lifetime)
+	}
+}
+
+func (c *Connection) stopTimeouts() {
+	c.timersMutex.Lock()
+	defer c.timersMutex.Unlock()
+
+	for _, timer := range []*wheelTimer{c.idleTimer, c.lifetimeTimer, c.closeTimer} {
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+// touch records client activity for the idle timeout.
+func (c *Connection) touch() {
+	c.lastActivity.Store(time.Now().UnixNano())
+}
+
+func (c *Connection) setIdleTimeoutUnsafe(d time.Duration) {
+	if c.idleTimer != nil {
+		c.idleTimer.Stop()
+		c.idleTimer = nil
+	}
+
+	c.idleTimeout = d
+	if d > 0 {
+		c.idleTimer = c.gw.timers.AfterFunc(d, c.checkIdle)
+	}
+}
+
+// setMaxLifetimeUnsafe closes the connection once it has been open for d.
+func (c *Connection) setMaxLifetimeUnsafe(d time.Duration) {
+	if c.lifetimeTimer != nil {
+		c.lifetimeTimer.Stop()
+		c.lifetimeTimer = nil
+	}
+
+	if d <= 0 {
+		return
+	}
+
+	remaining := time.Until(c.createdAt.Add(d))
+	if remaining < 0 {
+		remaining = 0
+	}
+
+	c.lifetimeTimer = c.gw.timers.AfterFunc(remaining, func() {
+		c.closeFromGateway(closeCodeGoingAway, "maximum lifetime reached")
+	})
+}
+
+func (c *Connection) checkIdle() {
+	c.timersMutex.Lock()
+
+	if c.idleTimeout <= 0 {
+		c.timersMutex.Unlock()
+		return
+	}
+
+	idle := time.Duration(time.Now().UnixNano() - c.lastActivity.Load())
+	if idle < c.idleTimeout {
+		c.idleTimer.Reset(c.idleTimeout - idle)
+		c.timersMutex.Unlock()
+		return
+	}
+
+	c.timersMutex.Unlock()
+
+	c.closeFromGateway(closeCodeNormal, "idle timeout")
+}
+
+// handleTimeoutsControlMessage lets the backend override the route's
+// timeouts. Values are in seconds and zero disables the timeout.
+func (c *Connection) handleTimeoutsControlMessage(ctrl *controlMessage) {
+	c.timersMutex.Lock()
+	defer c.timersMutex.Unlock()
+
+	if ctrl.IdleTimeout != nil {
+		c.setIdleTimeoutUnsafe(time.Duration(*ctrl.IdleTimeout) * time.Second)
+	}
+
+	if ctrl.MaxLifetime != nil {
+		c.setMaxLifetimeUnsafe(time.Duration(*ctrl.MaxLifetime) * time.Second)
+	}
+}
+
+// closeFromGateway starts the close handshake with the client on behalf of
+// the backend. The client is dropped if it does not answer in time.
+func (c *Connection) closeFromGateway(code uint16, reason string) {
+	if c.closed.Load() {
+		return
+	}
+
+	if !c.enqueueClose(grip.CloseEvent{Code: code, Reason: reason}.Content()) {
+		return
+	}
+
+	// nothing may follow the close frame
+	c.stopKeepAlive()
+	c.stopLiveness()
+
+	if c.clientClosed.Load() {
+		c.Drop()
+		return
+	}
+
+	c.timersMutex.Lock()
+	defer c.timersMutex.Unlock()
+
+	c.closeTimer = c.gw.timers.AfterFunc(closeTimeout, func() {
+		c.Drop()
+	})
+}
@@ -0,0 +1,329 @@
+package gateway
+
+import (
+	"math"
+	"runtime"
+	"sync"
+	"time"
+
+	"go.uber.org/atomic"
+)
+
+const (
+	defaultTimerTick = 10 * time.Millisecond
+
+	// every level of the wheel has 1<<timerSlotBits slots, each spanning a
+	// full turn of the level below it
+	timerSlotBits = 8
+	timerSlots    = 1 << timerSlotBits
+	timerSlotMask = timerSlots - 1
+	timerLevels   = 4
+
+	// timers further away are clamped to the last slot of the top level,
+	// which is more than a year at the default tick
+	maxTimerTicks = 1<<(timerSlotBits*timerLevels) - 1
+
+	timerShards = 16
+	timerQueue  = 1024
+)
+
+var timerWorkers = runtime.NumCPU() * 4
+
+// timerWheel is a hierarchical timing wheel shared by all connections of a
+// gateway. Scheduling and stopping a timer is O(1), and every tick only
+// visits the timers that expire or move down a level, so the cost of
+// keep-alives, timeouts and liveness pings stays flat as the number of
+// connections grows. Timers are spread over shards with their own lock so
+// connections don't contend on a single one. Timers fire with a resolution
+// of one tick.
+//
+// Expired callbacks run on a fixed set of workers. They should not block for
+// long, since a tick waits for room in the workers' queue.
+type timerWheel struct {
+	tick   time.Duration
+	start  time.Time
+	shards [timerShards]timerShard
+	next   atomic.Uint32
+
+	expired  chan func()
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+type timerShard struct {
+	mu     sync.Mutex
+	now    uint64
+	levels [timerLevels][timerSlots]*wheelTimer
+}
+
+// wheelTimer is a timer scheduled on a timerWheel.
+type wheelTimer struct {
+	w     *timerWheel
+	shard *timerShard
+	f     func()
+
+	deadline   uint64
+	level      int
+	slot       int
+	scheduled  bool
+	prev, next *wheelTimer
+}
+
+func newTimerWheel(tick time.Duration) *timerWheel {
+	w := &timerWheel{
+		tick:    tick,
+		start:   time.Now(),
+		expired: make(chan func(), timerQueue),
+		done:    make(chan struct{}),
+	}
+
+	for i := 0; i < timerWorkers; i++ {
+		go w.worker()
+	}
+
+	go w.run()
+
+	return w
+}
+
+// AfterFunc calls f on one of the wheel's workers once d has elapsed.
+func (w *timerWheel) AfterFunc(d time.Duration, f func()) *wheelTimer {
+	t := &wheelTimer{
+		w:     w,
+		shard: &w.shards[w.next.Inc()%timerShards],
+		f:     f,
+	}
+
+	t.shard.mu.Lock()
+	defer t.shard.mu.Unlock()
+
+	t.shard.scheduleUnsafe(t, w.deadline(d))
+
+	return t
+}
+
+// Stop stops the wheel and its workers. Pending timers never fire, nor do
+// timers scheduled afterwards, so anything waiting on one should also wait on
+// Done.
+func (w *timerWheel) Stop() {
+	w.stopOnce.Do(func() {
+		close(w.done)
+	})
+}
+
+// Done returns a channel that is closed once the wheel is stopped.
+func (w *timerWheel) Done() <-chan struct{} {
+	return w.done
+}
+
+// now returns the number of ticks since the wheel started.
+func (w *timerWheel) now() uint64 {
+	return uint64(time.Since(w.start) / w.tick)
+}
+
+// deadline returns the tick on which a timer set now to fire after d
+// expires. It counts from the wall clock rather than from the shard, which
+// may lag behind, and takes the part of the current tick that has passed
+// into account so the timer never fires early.
+func (w *timerWheel) deadline(d time.Duration) uint64 {
+	elapsed := time.Since(w.start)
+	if partial := elapsed % w.tick; d < math.MaxInt64-partial {
+		d += partial
+	}
+
+	return uint64(elapsed/w.tick) + w.ticks(d)
+}
+
+func (w *timerWheel) ticks(d time.Duration) uint64 {
+	// rounded up without adding to d, which could overflow
+	ticks := d / w.tick
+	if d%w.tick != 0 {
+		ticks++
+	}
+
+	if ticks < 1 {
+		return 1
+	} else if ticks > maxTimerTicks {
+		return maxTimerTicks
+	}
+
+	return uint64(ticks)
+}
+
+func (w *timerWheel) run() {
+	ticker := time.NewTicker(w.tick)
+	defer ticker.Stop()
+
+	var expired []func()
+	for {
+		select {
+		case <-ticker.C:
+		case <-w.done:
+			return
+		}
+
+		// catch up with the ticks that have passed rather than advancing one
+		// per receive, since the ticker drops ticks while the wheel is busy
+		now := w.now()
+
+		for i := range w.shards {
+			expired = w.shards[i].advance(now, expired[:0])
+
+			for _, f := range expired {
+				select {
+				case w.expired <- f:
+				case <-w.done:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (w *timerWheel) worker() {
+	for {
+		select {
+		case f := <-w.expired:
+			f()
+		case <-w.done:
+			return
+		}
+	}
+}
+
+// advance moves the shard ahead to the tick now and appends the callbacks of
+// the timers that expired on the way to expired.
+func (s *timerShard) advance(now uint64, expired []func()) []func() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for s.now < now {
+		expired = s.tickUnsafe(expired)
+	}
+
+	return expired
+}
+
+// tickUnsafe moves the shard one tick ahead.
+func (s *timerShard) tickUnsafe(expired []func()) []func() {
+	s.now++
+
+	// whenever a level completes a turn, the next slot of the level above
+	// is spread over the levels below, starting from the highest level that
+	// is due
+	level := 0
+	for level < timerLevels-1 && (s.now>>(uint(level)*timerSlotBits))&timerSlotMask == 0 {
+		level++
+	}
+
+	for ; level > 0; level-- {
+		slot := int(s.now>>(uint(level)*timerSlotBits)) & timerSlotMask
+
+		t := s.levels[level][slot]
+		s.levels[level][slot] = nil
+
+		for t != nil {
+			next := t.next
+			t.prev, t.next = nil, nil
+			s.placeUnsafe(t)
+			t = next
+		}
+	}
+
+	slot := int(s.now) & timerSlotMask
+
+	t := s.levels[0][slot]
+	s.levels[0][slot] = nil
+
+	for t != nil {
+		next := t.next
+		t.prev, t.next = nil, nil
+		t.scheduled = false
+		expired = append(expired, t.f)
+		t = next
+	}
+
+	return expired
+}
+
+func (s *timerShard) scheduleUnsafe(t *wheelTimer, deadline uint64) {
+	if deadline <= s.now {
+		deadline = s.now + 1
+	} else if deadline-s.now > maxTimerTicks {
+		deadline = s.now + maxTimerTicks
+	}
+
+	t.deadline = deadline
+	t.scheduled = true
+	s.placeUnsafe(t)
+}
+
+// placeUnsafe links t into the lowest level whose turn covers its deadline.
+func (s *timerShard) placeUnsafe(t *wheelTimer) {
+	var delta uint64
+	if t.deadline > s.now {
+		delta = t.deadline - s.now
+	}
+
+	level := 0
+	for level < timerLevels-1 && delta >= 1<<(uint(level+1)*timerSlotBits) {
+		level++
+	}
+
+	t.level = level
+	t.slot = int(t.deadline>>(uint(level)*timerSlotBits)) & timerSlotMask
+
+	t.prev = nil
+	t.next = s.levels[level][t.slot]
+	if t.next != nil {
+		t.next.prev = t
+	}
+
+	s.levels[level][t.slot] = t
+}
+
+func (s *timerShard) removeUnsafe(t *wheelTimer) {
+	if t.prev != nil {
+		t.prev.next = t.next
+	} else {
+		s.levels[t.level][t.slot] = t.next
+	}
+
+	if t.next != nil {
+		t.next.prev = t.prev
+	}
+
+	t.prev, t.next = nil, nil
+	t.scheduled = false
+}
+
+// Stop prevents the timer from firing. It returns false if the timer had
+// already fired or been stopped.
+func (t *wheelTimer) Stop() bool {
+	if t == nil {
+		return false
+	}
+
+	t.shard.mu.Lock()
+	defer t.shard.mu.Unlock()
+
+	if !t.scheduled {
+		return false
+	}
+
+	t.shard.removeUnsafe(t)
+	return true
+}
+
+// Reset reschedules the timer to fire after d, whether or not it is still
+// pending.
+func (t *wheelTimer) Reset(d time.Duration) {
+	t.shard.mu.Lock()
+	defer t.shard.mu.Unlock()
+
+	if t.scheduled {
+		t.shard.removeUnsafe(t)
+	}
+
+	t.shard.scheduleUnsafe(t, t.w.deadline(d))
+}
@@ -0,0 +1,201 @@
+package gateway
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// newManualWheel returns a wheel that only moves when advanced by the test.
+// Its tick is long enough for the wall clock to only move with it.
+func newManualWheel() *timerWheel {
+	return &timerWheel{
+		tick:  time.Hour,
+		start: time.Now(),
+		done:  make(chan struct{}),
+	}
+}
+
+// advance moves the wall clock of w and every shard to the tick now and runs
+// the expired callbacks.
+func advance(w *timerWheel, now uint64) {
+	w.start = time.Now().Add(-time.Duration(now)*w.tick - w.tick/2)
+
+	for i := range w.shards {
+		for _, f := range w.shards[i].advance(now, nil) {
+			f()
+		}
+	}
+}
+
+func TestTimerWheelLevels(t *testing.T) {
+	for _, start := range []uint64{0, 100, 1<<16 - 3, 1<<24 - 2} {
+		for _, ticks := range []uint64{1, 2, 255, 256, 257, 511, 1<<16 - 1, 1 << 16, 1<<16 + 1, 1<<24 + 1} {
+			var s timerShard
+			s.now = start
+
+			var fired bool
+			timer := &wheelTimer{shard: &s, f: func() { fired = true }}
+			s.scheduleUnsafe(timer, start+ticks)
+
+			for _, f := range s.advance(start+ticks-1, nil) {
+				f()
+			}
+
+			if fired {
+				t.Fatalf("timer set at %d for %d ticks fired early", start, ticks)
+			}
+
+			for _, f := range s.advance(start+ticks, nil) {
+				f()
+			}
+
+			if !fired {
+				t.Fatalf("timer set at %d for %d ticks didn't fire on time", start, ticks)
+			}
+
+			if timer.scheduled {
+				t.Fatalf("timer set at %d for %d ticks is still scheduled after firing", start, ticks)
+			}
+		}
+	}
+}
+
+func TestTimerWheelTicks(t *testing.T) {
+	for _, test := range []struct {
+		tick  time.Duration
+		d     time.Duration
+		ticks uint64
+	}{
+		{time.Millisecond, 0, 1},
+		{time.Millisecond, -time.Second, 1},
+		{time.Millisecond, time.Microsecond, 1},
+		{time.Millisecond, time.Millisecond, 1},
+		{time.Millisecond, time.Millisecond + 1, 2},
+		{time.Nanosecond, 1 << 40, maxTimerTicks},
+		{time.Hour, math.MaxInt64, math.MaxInt64/uint64(time.Hour) + 1},
+	} {
+		w := &timerWheel{tick: test.tick}
+		if ticks := w.ticks(test.d); ticks != test.ticks {
+			t.Errorf("expected %v at a tick of %v to take %d ticks, got %d", test.d, test.tick, test.ticks, ticks)
+		}
+	}
+}
+
+func TestTimerStopReset(t *testing.T) {
+	w := newManualWheel()
+
+	var fired int
+	timer := w.AfterFunc(10*time.Hour, func() { fired++ })
+
+	advance(w, 5)
+
+	if !timer.Stop() {
+		t.Fatal("expected Stop to stop a pending timer")
+	}
+
+	if timer.Stop() {
+		t.Fatal("expected Stop to report a stopped timer")
+	}
+
+	advance(w, 20)
+
+	if fired != 0 {
+		t.Fatal("stopped timer fired")
+	}
+
+	// the wall clock is half way through tick 20, so the timer expires on
+	// tick 24
+	timer.Reset(3 * time.Hour)
+	timer.Reset(300 * time.Hour)
+	timer.Reset(3 * time.Hour)
+
+	advance(w, 23)
+
+	if fired != 0 {
+		t.Fatal("reset timer fired early")
+	}
+
+	advance(w, 24)
+
+	if fired != 1 {
+		t.Fatalf("expected the reset timer to fire once, fired %d times", fired)
+	}
+
+	if timer.Stop() {
+		t.Fatal("expected Stop to report a fired timer")
+	}
+
+	timer.Reset(time.Hour)
+
+	advance(w, 26)
+
+	if fired != 2 {
+		t.Fatalf("expected a fired timer to fire again after Reset, fired %d times", fired)
+	}
+}
+
+func TestTimerWheelFires(t *testing.T) {
+	w := newTimerWheel(time.Millisecond)
+	defer w.Stop()
+
+	fired := make(chan time.Time, 1)
+	start := time.Now()
+	w.AfterFunc(20*time.Millisecond, func() { fired <- time.Now() })
+
+	select {
+	case at := <-fired:
+		if elapsed := at.Sub(start); elapsed < 20*time.Millisecond {
+			t.Fatalf("timer fired after %v", elapsed)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timer didn't fire")
+	}
+}
+
+func TestTimerWheelStop(t *testing.T) {
+	w := newTimerWheel(time.Millisecond)
+
+	fired := make(chan struct{}, 2)
+	w.AfterFunc(20*time.Millisecond, func() { fired <- struct{}{} })
+
+	w.Stop()
+	w.Stop()
+
+	select {
+	case <-w.Done():
+	default:
+		t.Fatal("expected Done to be closed")
+	}
+
+	w.AfterFunc(time.Millisecond, func() { fired <- struct{}{} })
+
+	select {
+	case <-fired:
+		t.Fatal("timer fired after Stop")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// TestLingerAfterClose checks that a send loop lingering for events doesn't
+// wait for a timer that will never fire once the gateway is closed.
+func TestLingerAfterClose(t *testing.T) {
+	g := newTestGateway(t)
+	g.defaultRoute.EventLinger = time.Hour
+
+	c := g.NewConnection("/", discardConn{}, nil)
+
+	g.Close()
+
+	for deadline := time.Now().Add(5 * time.Second); c.sendingEvents.Load(); {
+		if time.Now().After(deadline) {
+			t.Fatal("send loop is still lingering")
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+
+	if !c.opened.Load() {
+		t.Fatal("expected OPEN to be sent once the gateway was closed")
+	}
+}
@@ -1,14 +1,20 @@
 module github.com/ssttevee/go-wsproxy
 
 require (
-	github.com/gobwas/httphead v0.0.0-20180130184737-2c6c146eadee // indirect
-	github.com/gobwas/pool v0.2.0 // indirect
+	github.com/gobwas/pool v0.2.0
 	github.com/gobwas/ws v1.0.2
 	github.com/google/uuid v1.1.1
 	github.com/mailru/easygo v0.0.0-20190618140210-3c14a0dc985f
 	github.com/square/go-jose/v3 v3.0.0-20191119004800-96c717272387
 	go.uber.org/atomic v1.5.1
+)
+
+require (
+	github.com/gobwas/httphead v0.0.0-20180130184737-2c6c146eadee // indirect
+	golang.org/x/crypto v0.0.0-20190513172903-22d7a77e9e5f // indirect
+	golang.org/x/lint v0.0.0-20190930215403-16217165b5de // indirect
 	golang.org/x/sys v0.0.0-20191224085550-c709ea063b76 // indirect
+	golang.org/x/tools v0.0.0-20191029041327-9cc4af7d6b2c // indirect
 )
 
-go 1.12
+go 1.18
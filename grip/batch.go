@@ -0,0 +1,233 @@
+package grip
+
+import (
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ContentTypeBatch is the content type of batched requests and responses. The
+// body is a multipart/mixed message with one part per connection. Each part
+// carries a Connection-Id header, the Meta-* (or Set-Meta-* in responses)
+// headers of the connection and the connection's events encoded according to
+// the part's Content-Type.
+const ContentTypeBatch = "multipart/mixed"
+
+var ErrMissingFromBatch = errors.New("connection missing from batch response")
+
+// batchCall is a single connection's share of a batch.
+type batchCall struct {
+	c      *Connection
+	events []Event
+	handle func(Event) error
+
+	header   http.Header
+	err      error
+	done     chan struct{}
+	finished bool
+}
+
+func (call *batchCall) finish(h http.Header, err error) {
+	if call.finished {
+		return
+	}
+
+	call.header = h
+	call.err = err
+	call.finished = true
+	close(call.done)
+}
+
+type batch struct {
+	path  string
+	calls map[string]*batchCall
+	timer *time.Timer
+}
+
+// batcher coalesces the events of many connections into a single request per
+// path.
+type batcher struct {
+	t *HTTPTransport
+
+	mu      sync.Mutex
+	pending map[string]*batch
+}
+
+func (t *HTTPTransport) getBatcher() *batcher {
+	t.batcherOnce.Do(func() {
+		t.batcher = &batcher{
+			t:       t,
+			pending: map[string]*batch{},
+		}
+	})
+
+	return t.batcher
+}
+
+func (b *batcher) submit(c *Connection, events []Event, handle func(Event) error) (http.Header, error) {
+	call := &batchCall{
+		c:      c,
+		events: events,
+		handle: handle,
+		done:   make(chan struct{}),
+	}
+
+	b.mu.Lock()
+
+	pending := b.pending[c.path]
+	if pending != nil {
+		if _, ok := pending.calls[c.id]; ok {
+			// a connection may only appear once per batch
+			b.flushLocked(pending)
+			pending = nil
+		}
+	}
+
+	if pending == nil {
+		pending = &batch{
+			path:  c.path,
+			calls: map[string]*batchCall{},
+		}
+
+		pending.timer = time.AfterFunc(b.t.BatchWindow, func() {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+
+			if b.pending[pending.path] == pending {
+				b.flushLocked(pending)
+			}
+		})
+
+		b.pending[c.path] = pending
+	}
+
+	pending.calls[c.id] = call
+
+	if b.t.MaxBatchSize > 0 && len(pending.calls) >= b.t.MaxBatchSize {
+		b.flushLocked(pending)
+	}
+
+	b.mu.Unlock()
+
+	<-call.done
+
+	return call.header, call.err
+}
+
+func (b *batcher) flushLocked(pending *batch) {
+	pending.timer.Stop()
+	delete(b.pending, pending.path)
+
+	go b.send(pending)
+}
+
+func (b *batcher) send(pending *batch) {
+	err := b.t.sendBatch(pending)
+
+	if err == nil {
+		err = ErrMissingFromBatch
+	}
+
+	for _, call := range pending.calls {
+		call.finish(nil, err)
+	}
+}
+
+func (t *HTTPTransport) sendBatch(pending *batch) error {
+	body := newBodyBuffer()
+	mw := multipart.NewWriter(body)
+
+	for id, call := range pending.calls {
+		h := http.Header{}
+		h.Set("Connection-Id", id)
+		h.Set("Content-Type", t.Codec.ContentType())
+		call.c.writeMetaHeaders(h)
+
+		w, err := mw.CreatePart(textproto.MIMEHeader(h))
+		if err != nil {
+			body.release()
+			return err
+		}
+
+		if err := t.Codec.WriteEvents(w, call.events); err != nil {
+			body.release()
+			return err
+		}
+	}
+
+	if err := mw.Close(); err != nil {
+		body.release()
+		return err
+	}
+
+	req, done, err := t.newRequest(pending.path, mime.FormatMediaType(ContentTypeBatch, map[string]string{
+		"boundary": mw.Boundary(),
+	}), body)
+	if err != nil {
+		return err
+	}
+
+	defer done()
+
+	res, err := t.do(req)
+	if err != nil {
+		return err
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return UnexpectedStatusError(res.StatusCode)
+	}
+
+	r, err := decodedBody(res)
+	if err != nil {
+		return err
+	}
+
+	mediaType, params, err := mime.ParseMediaType(res.Header.Get("Content-Type"))
+	if err != nil {
+		return err
+	} else if mediaType != ContentTypeBatch || params["boundary"] == "" {
+		return UnexpectedContentTypeError(mediaType)
+	}
+
+	for mr := multipart.NewReader(r, params["boundary"]); ; {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		h := http.Header(part.Header)
+
+		call, ok := pending.calls[h.Get("Connection-Id")]
+		if !ok || call.finished {
+			continue
+		}
+
+		call.c.applyMetaHeaders(h)
+
+		// unread content of the part is skipped by the next call to NextPart
+		call.finish(h, t.readEvents(h, part, call.handle))
+	}
+}
+
+type UnexpectedStatusError int
+
+func (e UnexpectedStatusError) Error() string {
+	return "unexpected status: " + strconv.Itoa(int(e)) + " " + http.StatusText(int(e))
+}
+
+type UnexpectedContentTypeError string
+
+func (e UnexpectedContentTypeError) Error() string {
+	return "unexpected content type: " + string(e)
+}
@@ -0,0 +1,191 @@
+package grip
+
+import (
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"sync"
+	"testing"
+	"time"
+)
+
+// batchPart is what the test origin received for a connection.
+type batchPart struct {
+	meta   string
+	events []string
+}
+
+func TestBatch(t *testing.T) {
+	var mu sync.Mutex
+	var requests int
+	received := map[string]batchPart{}
+
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if err != nil || mediaType != ContentTypeBatch {
+			http.Error(w, "not a batch", http.StatusBadRequest)
+			return
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		requests++
+
+		var ids []string
+		for mr := multipart.NewReader(r.Body, params["boundary"]); ; {
+			part, err := mr.NextPart()
+			if err != nil {
+				break
+			}
+
+			id := part.Header.Get("Connection-Id")
+			ids = append(ids, id)
+
+			p := batchPart{meta: part.Header.Get("Meta-User")}
+			for it := NewEventIterator(part); ; {
+				event, err := it.Next()
+				if err != nil {
+					break
+				}
+
+				p.events = append(p.events, event.Type()+" "+string(event.Content()))
+			}
+
+			received[id] = p
+		}
+
+		mw := multipart.NewWriter(w)
+		w.Header().Set("Content-Type", mime.FormatMediaType(ContentTypeBatch, map[string]string{
+			"boundary": mw.Boundary(),
+		}))
+
+		for _, id := range ids {
+			// the origin leaves out connection c
+			if id == "c" {
+				continue
+			}
+
+			h := textproto.MIMEHeader{}
+			h.Set("Connection-Id", id)
+			h.Set("Content-Type", ContentTypeEvents)
+			h.Set("Set-Meta-Seen", id)
+
+			pw, err := mw.CreatePart(h)
+			if err != nil {
+				return
+			}
+
+			_ = WriteEvent(pw, NewTextEvent("m:"+id))
+		}
+
+		_ = mw.Close()
+	}))
+
+	defer origin.Close()
+
+	transport, err := NewHTTPTransport(origin.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// only a full batch is sent
+	transport.BatchWindow = time.Minute
+	transport.MaxBatchSize = 3
+
+	type result struct {
+		events []Event
+		err    error
+	}
+
+	ids := []string{"a", "b", "c"}
+	conns := map[string]*Connection{}
+	results := map[string]result{}
+
+	var wg sync.WaitGroup
+	var resultsMutex sync.Mutex
+	for _, id := range ids {
+		c := transport.NewConnection("/", id)
+		c.SetMeta("User", "user-"+id)
+		conns[id] = c
+
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+
+			_, events, err := c.SendEvents(NewTextEvent(id))
+
+			resultsMutex.Lock()
+			results[id] = result{events, err}
+			resultsMutex.Unlock()
+		}(id)
+	}
+
+	wg.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if requests != 1 {
+		t.Fatalf("expected 1 request, got %d", requests)
+	}
+
+	for _, id := range ids {
+		if p := received[id]; p.meta != "user-"+id || len(p.events) != 1 || p.events[0] != "TEXT "+id {
+			t.Errorf("expected the part of %s to carry its meta and events, got %+v", id, p)
+		}
+	}
+
+	for _, id := range []string{"a", "b"} {
+		res := results[id]
+		if res.err != nil {
+			t.Fatalf("%s: %v", id, res.err)
+		}
+
+		if len(res.events) != 1 || string(res.events[0].Content()) != "m:"+id {
+			t.Errorf("expected %s to receive its own events, got %v", id, res.events)
+		}
+
+		if seen := conns[id].Meta("Seen"); seen != id {
+			t.Errorf("expected meta of %s to be set from its part, got %q", id, seen)
+		}
+	}
+
+	if err := results["c"].err; err != ErrMissingFromBatch {
+		t.Errorf("expected %v for the missing part, got %v", ErrMissingFromBatch, err)
+	}
+
+	if seen := conns["c"].Meta("Seen"); seen != "" {
+		t.Errorf("expected no meta for the missing part, got %q", seen)
+	}
+}
+
+func TestUnexpectedStatus(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteHeader(http.StatusBadGateway)
+		_, _ = w.Write([]byte("<html>TEXT 5\r\nhello\r\n</html>"))
+	}))
+
+	defer origin.Close()
+
+	for _, batchWindow := range []time.Duration{0, time.Millisecond} {
+		transport, err := NewHTTPTransport(origin.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		transport.BatchWindow = batchWindow
+
+		_, events, err := transport.NewConnection("/", "id").SendEvents(OpenEvent)
+		if err != UnexpectedStatusError(http.StatusBadGateway) {
+			t.Errorf("batch window %v: expected %v, got %v", batchWindow, UnexpectedStatusError(http.StatusBadGateway), err)
+		}
+
+		if len(events) != 0 {
+			t.Errorf("batch window %v: expected no events, got %d", batchWindow, len(events))
+		}
+	}
+}
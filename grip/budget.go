@@ -0,0 +1,137 @@
+package grip
+
+import (
+	"sync"
+
+	"go.uber.org/atomic"
+)
+
+// closedChan is returned by Budget.Available while there is room left.
+var closedChan = func() chan struct{} {
+	c := make(chan struct{})
+	close(c)
+	return c
+}()
+
+// Budget accounts the memory held in buffers and queues across the process.
+// It only keeps the books, callers report what they hold with Add and
+// Release and decide themselves what to do once the budget is exhausted.
+//
+// A nil Budget has no limit.
+type Budget struct {
+	max  int64
+	used atomic.Int64
+
+	waitingMutex sync.Mutex
+	waiting      chan struct{}
+	notify       []func()
+}
+
+// NewBudget creates a budget of max bytes.
+func NewBudget(max int64) *Budget {
+	return &Budget{max: max}
+}
+
+// Add accounts n more bytes and reports whether the budget is exhausted.
+func (b *Budget) Add(n int) bool {
+	if b == nil {
+		return false
+	}
+
+	return b.used.Add(int64(n)) >= b.max
+}
+
+// Release gives back n bytes accounted by Add.
+func (b *Budget) Release(n int) {
+	if b == nil || n == 0 {
+		return
+	}
+
+	if b.used.Sub(int64(n)) >= b.max {
+		return
+	}
+
+	b.waitingMutex.Lock()
+
+	if b.Exhausted() {
+		b.waitingMutex.Unlock()
+		return
+	}
+
+	if b.waiting != nil {
+		close(b.waiting)
+		b.waiting = nil
+	}
+
+	notify := b.notify
+	b.notify = nil
+
+	b.waitingMutex.Unlock()
+
+	for _, f := range notify {
+		f()
+	}
+}
+
+// Exhausted reports whether the accounted bytes reached the budget.
+func (b *Budget) Exhausted() bool {
+	return b != nil && b.used.Load() >= b.max
+}
+
+// Available returns a channel that is closed once the budget is no longer
+// exhausted.
+func (b *Budget) Available() <-chan struct{} {
+	if b == nil {
+		return closedChan
+	}
+
+	b.waitingMutex.Lock()
+	defer b.waitingMutex.Unlock()
+
+	if !b.Exhausted() {
+		return closedChan
+	}
+
+	if b.waiting == nil {
+		b.waiting = make(chan struct{})
+	}
+
+	return b.waiting
+}
+
+// Notify calls f once the budget is no longer exhausted, right away if it
+// isn't. Unlike Available it holds no goroutine while waiting, f is called
+// by the Release that makes room and should return quickly.
+func (b *Budget) Notify(f func()) {
+	if b != nil {
+		b.waitingMutex.Lock()
+
+		if b.Exhausted() {
+			b.notify = append(b.notify, f)
+			b.waitingMutex.Unlock()
+			return
+		}
+
+		b.waitingMutex.Unlock()
+	}
+
+	f()
+}
+
+// Used returns the number of accounted bytes.
+func (b *Budget) Used() int64 {
+	if b == nil {
+		return 0
+	}
+
+	return b.used.Load()
+}
+
+// Max returns the size of the budget.
+func (b *Budget) Max() int64 {
+	if b == nil {
+		return 0
+	}
+
+	return b.max
+}
@@ -0,0 +1,42 @@
+package grip
+
+import (
+	"bytes"
+
+	"github.com/gobwas/pool/pbytes"
+)
+
+const (
+	// readBufferSize is the size of the buffer event streams are read
+	// through.
+	readBufferSize = 4 << 10
+
+	// bodyBufferSize is the initial capacity of request bodies.
+	bodyBufferSize = 4 << 10
+)
+
+// bodyBuffer is a request body backed by pooled memory. buf is the slice
+// taken from pbytes, which is what goes back to the pool even once the Buffer
+// has outgrown it.
+type bodyBuffer struct {
+	bytes.Buffer
+	buf []byte
+}
+
+// newBodyBuffer returns a buffer for a request body backed by pooled memory.
+// The request built from it takes it over, see newRequest.
+func newBodyBuffer() *bodyBuffer {
+	buf := pbytes.GetCap(bodyBufferSize)
+	return &bodyBuffer{
+		Buffer: *bytes.NewBuffer(buf),
+		buf:    buf,
+	}
+}
+
+// release returns the pooled memory of the buffer. It must not be used
+// afterwards.
+func (b *bodyBuffer) release() {
+	b.Reset()
+	pbytes.Put(b.buf)
+	b.buf = nil
+}
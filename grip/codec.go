@@ -0,0 +1,217 @@
+package grip
+
+import (
+	"encoding/json"
+	"io"
+	"mime"
+)
+
+const (
+	ContentTypeEvents     = "application/websocket-events"
+	ContentTypeEventsJSON = "application/websocket-events+json"
+)
+
+// Iterator yields the events decoded from a stream until it returns Done.
+//
+// Events returned by Next belong to the caller. Whether their content is a
+// buffer taken from pbytes depends on the codec, so callers that are done
+// with an event hand it to ReleaseEvent, which only puts back pooled
+// buffers. The other way round, transports never release or keep the content
+// of events passed to them.
+type Iterator interface {
+	Next() (Event, error)
+}
+
+// Codec encodes and decodes the body of WebSocket-over-HTTP requests and
+// responses.
+type Codec interface {
+	ContentType() string
+	WriteEvents(w io.Writer, events []Event) error
+	NewIterator(r io.Reader, maxEventSize, maxTotalSize int64) Iterator
+}
+
+var (
+	// EventsCodec is the application/websocket-events framing.
+	EventsCodec Codec = eventsCodec{}
+
+	// JSONCodec encodes events as a json array of objects with type,
+	// content and content-bin fields.
+	JSONCodec Codec = jsonCodec{}
+)
+
+// CodecForContentType returns the codec of a content type header value.
+func CodecForContentType(contentType string) (Codec, bool) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, false
+	}
+
+	switch mediaType {
+	case ContentTypeEvents:
+		return EventsCodec, true
+	case ContentTypeEventsJSON:
+		return JSONCodec, true
+	}
+
+	return nil, false
+}
+
+type eventsCodec struct{}
+
+func (eventsCodec) ContentType() string {
+	return ContentTypeEvents
+}
+
+func (eventsCodec) WriteEvents(w io.Writer, events []Event) error {
+	for _, event := range events {
+		if err := WriteEvent(w, event); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (eventsCodec) NewIterator(r io.Reader, maxEventSize, maxTotalSize int64) Iterator {
+	it := NewEventIterator(r)
+	it.SetLimits(maxEventSize, maxTotalSize)
+	return it
+}
+
+type jsonEvent struct {
+	Type          string  `json:"type"`
+	Content       *string `json:"content,omitempty"`
+	BinaryContent []byte  `json:"content-bin,omitempty"`
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) ContentType() string {
+	return ContentTypeEventsJSON
+}
+
+func (jsonCodec) WriteEvents(w io.Writer, events []Event) error {
+	items := make([]jsonEvent, len(events))
+	for i, event := range events {
+		items[i].Type = event.Type()
+
+		switch event := event.(type) {
+		case CloseEvent:
+			// the close code leads the content, as in the events format
+			items[i].BinaryContent = event.Content()
+
+		case DataEvent:
+			if event.Type() == EventTypeBinary {
+				items[i].BinaryContent = event.Bytes()
+			} else {
+				text := event.Text()
+				items[i].Content = &text
+			}
+		}
+	}
+
+	return json.NewEncoder(w).Encode(items)
+}
+
+func (jsonCodec) NewIterator(r io.Reader, maxEventSize, maxTotalSize int64) Iterator {
+	if maxTotalSize > 0 {
+		r = &limitedReader{r: r, n: maxTotalSize, limit: maxTotalSize}
+	}
+
+	return &jsonIterator{
+		dec:          json.NewDecoder(r),
+		maxEventSize: maxEventSize,
+	}
+}
+
+// jsonIterator decodes the array one element at a time, so events are
+// yielded as soon as they arrive.
+type jsonIterator struct {
+	dec          *json.Decoder
+	maxEventSize int64
+	started      bool
+	err          error
+}
+
+func (it *jsonIterator) Next() (Event, error) {
+	if it.err != nil {
+		return nil, it.err
+	}
+
+	event, err := it.next()
+	if err != nil {
+		it.err = err
+	}
+
+	return event, err
+}
+
+func (it *jsonIterator) next() (Event, error) {
+	if !it.started {
+		it.started = true
+
+		tok, err := it.dec.Token()
+		if err == io.EOF {
+			// an empty body carries no events
+			return nil, Done
+		} else if err != nil {
+			return nil, err
+		}
+
+		if delim, ok := tok.(json.Delim); !ok || delim != '[' {
+			return nil, MalformedHeaderError("expected a json array of events")
+		}
+	}
+
+	if !it.dec.More() {
+		if _, err := it.dec.Token(); err != nil {
+			return nil, err
+		}
+
+		return nil, Done
+	}
+
+	var item jsonEvent
+	if err := it.dec.Decode(&item); err != nil {
+		return nil, err
+	}
+
+	content := item.BinaryContent
+	if item.Content != nil {
+		content = []byte(*item.Content)
+	}
+
+	if it.maxEventSize > 0 && int64(len(content)) > it.maxEventSize {
+		return nil, EventTooLargeError(len(content))
+	}
+
+	return newEvent(item.Type, content)
+}
+
+// limitedReader is like io.LimitedReader except that it fails with
+// StreamTooLargeError instead of ending the stream early.
+type limitedReader struct {
+	r     io.Reader
+	n     int64
+	limit int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.n <= 0 {
+		// only fail if there actually is more to read
+		var b [1]byte
+		if n, err := l.r.Read(b[:]); n == 0 {
+			return 0, err
+		}
+
+		return 0, StreamTooLargeError(l.limit)
+	}
+
+	if int64(len(p)) > l.n {
+		p = p[:l.n]
+	}
+
+	n, err := l.r.Read(p)
+	l.n -= int64(n)
+	return n, err
+}
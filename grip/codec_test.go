@@ -0,0 +1,186 @@
+package grip
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestJSONCodecRoundTrip(t *testing.T) {
+	events := []Event{
+		OpenEvent,
+		NewTextEvent("hello"),
+		NewTextEvent(""),
+		NewBinaryEvent([]byte{0, 1, 2}),
+		NewCloseEvent(1001, "going away"),
+		PingEvent,
+		DisconnectEvent,
+	}
+
+	var body bytes.Buffer
+	if err := JSONCodec.WriteEvents(&body, events); err != nil {
+		t.Fatal(err)
+	}
+
+	var items []map[string]interface{}
+	if err := json.Unmarshal(body.Bytes(), &items); err != nil {
+		t.Fatal(err)
+	}
+
+	// text goes in content, binary and the close code in content-bin
+	for i, want := range []map[string]interface{}{
+		{"type": "OPEN"},
+		{"type": "TEXT", "content": "hello"},
+		{"type": "TEXT", "content": ""},
+		{"type": "BINARY", "content-bin": "AAEC"},
+		{"type": "CLOSE", "content-bin": "A+lnb2luZyBhd2F5"},
+		{"type": "PING"},
+		{"type": "DISCONNECT"},
+	} {
+		if !reflect.DeepEqual(items[i], want) {
+			t.Errorf("expected event %d to encode as %v, got %v", i, want, items[i])
+		}
+	}
+
+	it := JSONCodec.NewIterator(&body, 0, 0)
+
+	var decoded []Event
+	for {
+		event, err := it.Next()
+		if err == Done {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+
+		decoded = append(decoded, event)
+	}
+
+	if len(decoded) != len(events) {
+		t.Fatalf("expected %d events, got %d", len(events), len(decoded))
+	}
+
+	for i, event := range events {
+		if decoded[i].Type() != event.Type() || !bytes.Equal(decoded[i].Content(), event.Content()) {
+			t.Errorf("expected %s %q, got %s %q", event.Type(), event.Content(), decoded[i].Type(), decoded[i].Content())
+		}
+	}
+
+	if ce, ok := decoded[4].(CloseEvent); !ok || ce.Code != 1001 || ce.Reason != "going away" {
+		t.Errorf("expected close 1001 going away, got %#v", decoded[4])
+	}
+}
+
+func TestJSONCodecLimits(t *testing.T) {
+	body := `[{"type":"TEXT","content":"hello"},{"type":"TEXT","content":"world, hello"}]`
+
+	tests := []struct {
+		name                       string
+		maxEventSize, maxTotalSize int64
+		events                     int
+		err                        error
+	}{
+		{"no limits", 0, 0, 2, Done},
+		{"event too large", 8, 0, 1, EventTooLargeError(12)},
+		{"stream too large", 0, 40, 1, StreamTooLargeError(40)},
+		{"stream at the limit", 0, int64(len(body)), 2, Done},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			it := JSONCodec.NewIterator(strings.NewReader(body), test.maxEventSize, test.maxTotalSize)
+
+			var n int
+			var err error
+			for ; ; n++ {
+				if _, err = it.Next(); err != nil {
+					break
+				}
+			}
+
+			if n != test.events {
+				t.Errorf("expected %d events, got %d", test.events, n)
+			}
+
+			if err != test.err {
+				t.Fatalf("expected %#v, got %#v", test.err, err)
+			}
+		})
+	}
+}
+
+func TestReadEventsContentType(t *testing.T) {
+	var events, jsonEvents bytes.Buffer
+	if err := EventsCodec.WriteEvents(&events, []Event{NewTextEvent("events")}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := JSONCodec.WriteEvents(&jsonEvents, []Event{NewTextEvent("json")}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		codec       Codec
+		contentType string
+		body        []byte
+		content     string
+	}{
+		{"events", JSONCodec, ContentTypeEvents, events.Bytes(), "events"},
+		{"json", EventsCodec, ContentTypeEventsJSON + "; charset=utf-8", jsonEvents.Bytes(), "json"},
+		{"fallback to events", EventsCodec, "", events.Bytes(), "events"},
+		{"fallback to json", JSONCodec, "text/plain", jsonEvents.Bytes(), "json"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			transport := &HTTPTransport{Codec: test.codec}
+
+			h := http.Header{}
+			if test.contentType != "" {
+				h.Set("Content-Type", test.contentType)
+			}
+
+			var got []string
+			err := transport.readEvents(h, bytes.NewReader(test.body), func(event Event) error {
+				got = append(got, string(event.Content()))
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(got) != 1 || got[0] != test.content {
+				t.Fatalf("expected [%s], got %q", test.content, got)
+			}
+		})
+	}
+}
+
+// BenchmarkWriteEvents encodes events into a request body the way
+// sendEvents does.
+func BenchmarkWriteEvents(b *testing.B) {
+	events := []Event{
+		NewTextEvent("hello"),
+		NewBinaryEvent(make([]byte, 1<<10)),
+		NewCloseEvent(1000, "bye"),
+	}
+
+	for _, codec := range []Codec{EventsCodec, JSONCodec} {
+		b.Run(codec.ContentType(), func(b *testing.B) {
+			b.ReportAllocs()
+
+			for i := 0; i < b.N; i++ {
+				body := newBodyBuffer()
+				if err := codec.WriteEvents(body, events); err != nil {
+					b.Fatal(err)
+				}
+
+				body.release()
+			}
+		})
+	}
+}
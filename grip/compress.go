@@ -0,0 +1,46 @@
+package grip
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"strings"
+)
+
+const acceptEncoding = "gzip, deflate"
+
+// gzipBody compresses body with gzip into a buffer from newBodyBuffer.
+func gzipBody(body *bodyBuffer, level int) (*bodyBuffer, error) {
+	buf := newBodyBuffer()
+	zw, err := gzip.NewWriterLevel(buf, level)
+	if err != nil {
+		buf.release()
+		return nil, err
+	}
+
+	if _, err := body.WriteTo(zw); err != nil {
+		buf.release()
+		return nil, err
+	}
+
+	if err := zw.Close(); err != nil {
+		buf.release()
+		return nil, err
+	}
+
+	return buf, nil
+}
+
+// decodedBody returns a reader for the response body with its content
+// encoding removed.
+func decodedBody(res *http.Response) (io.Reader, error) {
+	switch strings.ToLower(strings.TrimSpace(res.Header.Get("Content-Encoding"))) {
+	case "gzip", "x-gzip":
+		return gzip.NewReader(res.Body)
+	case "deflate":
+		return zlib.NewReader(res.Body)
+	default:
+		return res.Body, nil
+	}
+}
@@ -0,0 +1,147 @@
+package grip
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+
+	"go.uber.org/atomic"
+)
+
+const (
+	metaHeaderPrefix    = "Meta-"
+	setMetaHeaderPrefix = "Set-Meta-"
+)
+
+type Connection struct {
+	transport Transport
+	path      string
+	id        string
+
+	metaMutex sync.RWMutex
+	meta      map[string]string
+
+	// ctx is the context of requests made before the connection is closed
+	ctx    context.Context
+	cancel context.CancelFunc
+	closed atomic.Bool
+}
+
+func newConnection(t Transport, path string, id string) *Connection {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &Connection{
+		transport: t,
+		path:      path,
+		id:        id,
+		meta:      map[string]string{},
+		ctx:       ctx,
+		cancel:    cancel,
+	}
+}
+
+func (c *Connection) ID() string {
+	return c.id
+}
+
+// Close cancels the requests to the origin that are under way, including
+// responses the origin holds open. Requests made afterwards, such as the one
+// carrying DISCONNECT, are still sent, but their responses are not read since
+// nothing is left to handle the events. Requests batched with other
+// connections are not cancelled.
+func (c *Connection) Close() {
+	c.closed.Store(true)
+	c.cancel()
+}
+
+// context returns the context of a request made now.
+func (c *Connection) context() context.Context {
+	if c.closed.Load() {
+		return context.Background()
+	}
+
+	return c.ctx
+}
+
+// Meta returns the value of a connection meta field. Keys are canonicalized
+// like http header names.
+func (c *Connection) Meta(key string) string {
+	c.metaMutex.RLock()
+	defer c.metaMutex.RUnlock()
+
+	return c.meta[http.CanonicalHeaderKey(key)]
+}
+
+// SetMeta sets a connection meta field, which is sent to the origin as a
+// Meta-* header with every request. An empty value removes the field.
+func (c *Connection) SetMeta(key, value string) {
+	c.metaMutex.Lock()
+	defer c.metaMutex.Unlock()
+
+	c.setMetaUnsafe(http.CanonicalHeaderKey(key), value)
+}
+
+func (c *Connection) setMetaUnsafe(key, value string) {
+	if value == "" {
+		delete(c.meta, key)
+	} else {
+		c.meta[key] = value
+	}
+}
+
+func (c *Connection) writeMetaHeaders(h http.Header) {
+	c.metaMutex.RLock()
+	defer c.metaMutex.RUnlock()
+
+	for k, v := range c.meta {
+		h.Set(metaHeaderPrefix+k, v)
+	}
+}
+
+// applyMetaHeaders applies the Set-Meta-* headers of an origin response.
+func (c *Connection) applyMetaHeaders(h http.Header) {
+	c.metaMutex.Lock()
+	defer c.metaMutex.Unlock()
+
+	for k, vs := range h {
+		if len(k) <= len(setMetaHeaderPrefix) || !strings.HasPrefix(k, setMetaHeaderPrefix) {
+			continue
+		}
+
+		// last one takes precedence
+		c.setMetaUnsafe(k[len(setMetaHeaderPrefix):], vs[len(vs)-1])
+	}
+}
+
+// SendEvents sends e to the origin and returns the events in its response
+// once the response has ended.
+func (c *Connection) SendEvents(e ...Event) (http.Header, []Event, error) {
+	var incomingEvents []Event
+	h, err := c.StreamEvents(func(event Event) error {
+		incomingEvents = append(incomingEvents, event)
+		return nil
+	}, e...)
+	if err != nil {
+		return h, nil, err
+	}
+
+	return h, incomingEvents, nil
+}
+
+// StreamEvents sends e to the origin and calls handle for each event in the
+// response as it arrives. Returning an error from handle aborts the response.
+func (c *Connection) StreamEvents(handle func(Event) error, e ...Event) (http.Header, error) {
+	return c.transport.sendEvents(c, e, handle)
+}
+
+// StartEvents is like StreamEvents, but when the origin may hold responses
+// open it returns as soon as the response has started. The rest of the
+// response is handled on its own goroutine, so the events that follow can be
+// sent in a new request while the origin keeps pushing events over this one.
+// Responses that overlap this way call handle concurrently. done is called
+// with the error that ended the response, or nil, unless StartEvents returns
+// an error.
+func (c *Connection) StartEvents(handle func(Event) error, done func(error), e ...Event) (http.Header, error) {
+	return c.transport.startEvents(c, e, handle, done)
+}
@@ -2,6 +2,8 @@ package grip
 
 import (
 	"encoding/binary"
+
+	"github.com/gobwas/pool/pbytes"
 )
 
 const (
@@ -39,6 +41,9 @@ func (e EmptyEvent) Content() []byte {
 type DataEvent struct {
 	t string
 	p []byte
+
+	// pooled is set when p is a buffer from pbytes owned by the event
+	pooled bool
 }
 
 func NewBinaryEvent(p []byte) Event {
@@ -55,6 +60,25 @@ func NewTextEvent(s string) Event {
 	}
 }
 
+// NewPooledEvent creates a TEXT or BINARY event whose content p is a buffer
+// taken from pbytes. The event takes it over, ReleaseEvent puts it back.
+func NewPooledEvent(typ string, p []byte) Event {
+	return DataEvent{
+		t:      typ,
+		p:      p,
+		pooled: true,
+	}
+}
+
+// ReleaseEvent puts the content of e back to pbytes if the event owns a
+// pooled buffer, and does nothing otherwise. The content must not be used
+// afterwards.
+func ReleaseEvent(e Event) {
+	if e, ok := e.(DataEvent); ok && e.pooled {
+		pbytes.Put(e.p)
+	}
+}
+
 func (e DataEvent) Type() string {
 	return e.t
 }
@@ -71,6 +95,12 @@ func (e DataEvent) Bytes() []byte {
 	return e.p
 }
 
+// Pooled reports whether the content is a buffer from pbytes owned by the
+// event, see ReleaseEvent.
+func (e DataEvent) Pooled() bool {
+	return e.pooled
+}
+
 type CloseEvent struct {
 	Code   uint16
 	Reason string
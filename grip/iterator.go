@@ -1,12 +1,26 @@
 package grip
 
 import (
-	"bufio"
-	"bytes"
 	"encoding/binary"
 	"errors"
 	"io"
 	"strconv"
+
+	"github.com/gobwas/pool/pbytes"
+)
+
+const (
+	// DefaultMaxEventSize is the largest event content accepted by an
+	// EventIterator unless changed with SetLimits.
+	DefaultMaxEventSize = 16 << 20
+
+	// maxHeaderSize bounds the length of an event header line excluding
+	// CRLF, which only holds the event type and a hex encoded content size.
+	maxHeaderSize = 64
+
+	// maxContentHint bounds the buffer allocated for event content before
+	// any of it has arrived.
+	maxContentHint = 64 << 10
 )
 
 type UnexpectedEventTypeError string
@@ -21,91 +35,165 @@ func (e InvalidContentSizeError) Error() string {
 	return "invalid content size: " + string(e)
 }
 
+// MalformedHeaderError is returned when an event header line is too long or
+// is not terminated by CRLF.
+type MalformedHeaderError string
+
+func (e MalformedHeaderError) Error() string {
+	return "malformed event header: " + string(e)
+}
+
+// MalformedContentError is returned when the content of an event is not
+// followed by CRLF.
+type MalformedContentError string
+
+func (e MalformedContentError) Error() string {
+	return "malformed content of " + string(e) + " event: missing trailing CRLF"
+}
+
+// TruncatedEventError is returned when the stream ends in the middle of an
+// event.
+type TruncatedEventError string
+
+func (e TruncatedEventError) Error() string {
+	return "truncated event: " + string(e)
+}
+
+func (e TruncatedEventError) Unwrap() error {
+	return io.ErrUnexpectedEOF
+}
+
+// EventTooLargeError is returned when the content of a single event exceeds
+// the maximum event size.
+type EventTooLargeError int64
+
+func (e EventTooLargeError) Error() string {
+	return "event content of " + strconv.FormatInt(int64(e), 10) + " bytes exceeds the limit"
+}
+
+// StreamTooLargeError is returned when the events read from a stream exceed
+// the maximum total size.
+type StreamTooLargeError int64
+
+func (e StreamTooLargeError) Error() string {
+	return "event stream exceeds the limit of " + strconv.FormatInt(int64(e), 10) + " bytes"
+}
+
 var Done = errors.New("done")
 
+// EventIterator decodes a stream of events, reading the content of each
+// event into its own slice.
 type EventIterator struct {
-	r    io.Reader
-	buf  bytes.Buffer
-	tmp  []byte
-	done bool
+	er  *EventReader
+	err error
 }
 
 func NewEventIterator(r io.Reader) *EventIterator {
 	return &EventIterator{
-		r:   r,
-		tmp: make([]byte, 1<<10),
+		er: NewEventReader(r),
 	}
 }
 
-func (it *EventIterator) read() error {
-	n, err := it.r.Read(it.tmp)
-	if err != nil && (err != io.EOF || n == 0) {
-		return err
-	}
-
-	_, _ = it.buf.Write(it.tmp[:n])
+// Release returns the iterator's buffers to their pools. The EventIterator
+// must not be used afterwards, the events it returned stay valid.
+func (it *EventIterator) Release() {
+	it.er.Release()
+}
 
-	return nil
+// SetLimits sets the largest content size of a single event and the largest
+// number of bytes read over all events. A limit of zero or less disables it.
+func (it *EventIterator) SetLimits(maxEventSize, maxTotalSize int64) {
+	it.er.SetLimits(maxEventSize, maxTotalSize)
 }
 
+// Next returns the next event in the stream or Done once the stream has ended
+// cleanly. Any other error is returned again by subsequent calls. The content
+// of TEXT and BINARY events is taken from pbytes, see ReleaseEvent.
 func (it *EventIterator) Next() (Event, error) {
-	if it.done && it.buf.Len() == 0 {
-		return nil, Done
+	if it.err != nil {
+		return nil, it.err
 	}
 
-	// read event header
-	adv, line, err := bufio.ScanLines(it.buf.Bytes(), false)
+	event, err := it.next()
+	if err != nil {
+		it.err = err
+	}
+
+	return event, err
+}
+
+func (it *EventIterator) next() (Event, error) {
+	h, r, err := it.er.Next()
 	if err != nil {
 		return nil, err
 	}
 
-	if adv == 0 {
-		if err := it.read(); err == io.EOF {
-			if it.buf.Len() > 0 {
-				return nil, io.ErrUnexpectedEOF
-			}
+	var content []byte
+	if h.Size > 0 {
+		if content, err = readContent(r, h.Size); err != nil {
+			return nil, err
+		}
 
-			it.done = true
-			return nil, Done
-		} else if err != nil {
+		// consume the trailing CRLF
+		if _, err := r.Read(nil); err != io.EOF {
+			pbytes.Put(content)
 			return nil, err
 		}
+	}
 
-		return it.Next()
+	if h.Type == EventTypeText || h.Type == EventTypeBinary {
+		return NewPooledEvent(h.Type, content), nil
 	}
 
-	// advance the buffer offset
-	_ = it.buf.Next(adv)
+	// other events don't keep their content
+	defer pbytes.Put(content)
 
-	var contentLength int64
-	if pos := bytes.IndexByte(line, ' '); pos != -1 {
-		if contentLength, err = strconv.ParseInt(string(line[pos+1:]), 16, 64); err != nil {
-			return nil, InvalidContentSizeError(line[pos+1:])
-		}
+	return newEvent(h.Type, content)
+}
 
-		line = line[:pos]
+// readContent reads size bytes of content from r into a buffer from pbytes.
+// The size comes from the stream, so the buffer grows as the content arrives
+// rather than being allocated up front.
+func readContent(r io.Reader, size int64) ([]byte, error) {
+	hint := size
+	if hint > maxContentHint {
+		hint = maxContentHint
 	}
 
-	for int(contentLength) > it.buf.Len() {
-		if err := it.read(); err == io.EOF {
-			return nil, io.ErrUnexpectedEOF
-		} else if err != nil {
+	buf := pbytes.GetLen(int(hint))
+	for n := 0; ; {
+		m, err := io.ReadFull(r, buf[n:])
+		n += m
+
+		if err != nil {
+			pbytes.Put(buf)
 			return nil, err
 		}
-	}
 
-	var content []byte
-	if contentLength > 0 {
-		content = it.buf.Next(int(contentLength) + 2)[:int(contentLength)]
+		if int64(n) == size {
+			return buf, nil
+		}
+
+		next := int64(2 * n)
+		if next > size {
+			next = size
+		}
+
+		grown := pbytes.GetLen(int(next))
+		copy(grown, buf)
+		pbytes.Put(buf)
+		buf = grown
 	}
+}
 
-	switch string(line) {
+func newEvent(typ string, content []byte) (Event, error) {
+	switch typ {
 	case "OPEN", "PING", "PONG", "DISCONNECT":
-		return EmptyEvent(line), nil
+		return EmptyEvent(typ), nil
 
 	case "TEXT", "BINARY":
 		return DataEvent{
-			t: string(line),
+			t: typ,
 			p: content,
 		}, nil
 
@@ -120,6 +208,6 @@ func (it *EventIterator) Next() (Event, error) {
 		}, nil
 
 	default:
-		return nil, UnexpectedEventTypeError(line)
+		return nil, UnexpectedEventTypeError(typ)
 	}
 }
@@ -0,0 +1,213 @@
+package grip
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestEventIterator(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   string
+		limits [2]int64
+
+		events []string
+		err    error
+	}{
+		{
+			name:   "events",
+			data:   "OPEN\r\nTEXT 5\r\nhello\r\nBINARY 0\r\nCLOSE 2\r\n\x03\xe8\r\nPING\r\n",
+			events: []string{"OPEN", "TEXT hello", "BINARY", "CLOSE \x03\xe8", "PING"},
+			err:    Done,
+		},
+		{
+			name: "empty",
+			err:  Done,
+		},
+		{
+			name: "header without crlf",
+			data: "OPEN\n",
+			err:  MalformedHeaderError(strconv.Quote("OPEN\n")),
+		},
+		{
+			name: "header without line end",
+			data: "PING",
+			err:  TruncatedEventError(strconv.Quote("PING")),
+		},
+		{
+			name:   "content without crlf",
+			data:   "PING\r\nTEXT 5\r\nhelloXX",
+			events: []string{"PING"},
+			err:    MalformedContentError("TEXT"),
+		},
+		{
+			name: "content cut short",
+			data: "TEXT 5\r\nhel",
+			err:  TruncatedEventError("TEXT"),
+		},
+		{
+			name: "trailer cut short",
+			data: "TEXT 5\r\nhello\r",
+			err:  TruncatedEventError("TEXT"),
+		},
+		{
+			name: "invalid size",
+			data: "TEXT zz\r\nhello\r\n",
+			err:  InvalidContentSizeError("zz"),
+		},
+		{
+			name: "negative size",
+			data: "TEXT -1\r\n",
+			err:  InvalidContentSizeError("-1"),
+		},
+		{
+			name: "unknown type",
+			data: "NOPE\r\n",
+			err:  UnexpectedEventTypeError("NOPE"),
+		},
+		{
+			name: "long header",
+			data: "TEXT " + strings.Repeat("0", maxHeaderSize) + "\r\n",
+			err:  MalformedHeaderError("header line too long"),
+		},
+		{
+			name:   "event too large",
+			data:   "TEXT 5\r\nhello\r\nTEXT 10\r\n",
+			limits: [2]int64{8, 0},
+			events: []string{"TEXT hello"},
+			err:    EventTooLargeError(16),
+		},
+		{
+			name:   "stream too large",
+			data:   "TEXT 5\r\nhello\r\nTEXT 5\r\nworld\r\n",
+			limits: [2]int64{0, 24},
+			events: []string{"TEXT hello"},
+			err:    StreamTooLargeError(24),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			it := NewEventIterator(strings.NewReader(test.data))
+			defer it.Release()
+
+			if test.limits != [2]int64{} {
+				it.SetLimits(test.limits[0], test.limits[1])
+			}
+
+			var events []string
+			var err error
+			for {
+				var event Event
+				if event, err = it.Next(); err != nil {
+					break
+				}
+
+				events = append(events, strings.TrimSuffix(event.Type()+" "+string(event.Content()), " "))
+				ReleaseEvent(event)
+			}
+
+			if strings.Join(events, ",") != strings.Join(test.events, ",") {
+				t.Errorf("expected events %q, got %q", test.events, events)
+			}
+
+			if err != test.err {
+				t.Fatalf("expected %#v, got %#v", test.err, err)
+			}
+
+			if _, again := it.Next(); again != err {
+				t.Errorf("expected %#v again, got %#v", err, again)
+			}
+
+			if _, ok := err.(TruncatedEventError); ok && !errors.Is(err, io.ErrUnexpectedEOF) {
+				t.Errorf("expected %#v to be %v", err, io.ErrUnexpectedEOF)
+			}
+		})
+	}
+}
+
+func FuzzEventIterator(f *testing.F) {
+	f.Add([]byte("OPEN\r\n"))
+	f.Add([]byte("OPEN\r\nTEXT 5\r\nhello\r\nBINARY 3\r\n\x00\x01\x02\r\nCLOSE 2\r\n\x03\xe8\r\nDISCONNECT\r\n"))
+	f.Add([]byte("TEXT 7fffffffffffffff\r\nx\r\n"))
+	f.Add([]byte("TEXT 10000000000\r\nx\r\n"))
+	f.Add([]byte("TEXT -1\r\n"))
+	f.Add([]byte("TEXT 5\r\nhel"))
+	f.Add([]byte("TEXT 5\r\nhelloXX"))
+	f.Add([]byte("PING"))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		for _, limits := range [][2]int64{
+			{0, 0},
+			{0, 1 << 16},
+			{1 << 10, 0},
+			{1 << 10, 1 << 12},
+		} {
+			it := NewEventIterator(bytes.NewReader(data))
+			it.SetLimits(limits[0], limits[1])
+
+			var total int64
+			for {
+				event, err := it.Next()
+				if err != nil {
+					break
+				}
+
+				size := int64(len(event.Content()))
+				if limits[0] > 0 && size > limits[0] {
+					t.Fatalf("event of %d bytes exceeds the limit of %d", size, limits[0])
+				}
+
+				total += size
+				if limits[1] > 0 && total > limits[1] {
+					t.Fatalf("%d bytes of content exceed the limit of %d", total, limits[1])
+				}
+
+				ReleaseEvent(event)
+			}
+
+			it.Release()
+		}
+	})
+}
+
+func BenchmarkEventIteratorNext(b *testing.B) {
+	const events = 64
+
+	for _, size := range []int{16, 1 << 10, 64 << 10} {
+		b.Run(strconv.Itoa(size), func(b *testing.B) {
+			var stream bytes.Buffer
+			for i := 0; i < events; i++ {
+				if err := WriteEvent(&stream, NewBinaryEvent(make([]byte, size))); err != nil {
+					b.Fatal(err)
+				}
+			}
+
+			b.SetBytes(int64(size))
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			var it *EventIterator
+			for i := 0; i < b.N; i++ {
+				if i%events == 0 {
+					if it != nil {
+						it.Release()
+					}
+
+					it = NewEventIterator(bytes.NewReader(stream.Bytes()))
+				}
+
+				event, err := it.Next()
+				if err != nil {
+					b.Fatal(err)
+				}
+
+				ReleaseEvent(event)
+			}
+		})
+	}
+}
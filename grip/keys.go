@@ -0,0 +1,179 @@
+package grip
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+
+	"github.com/square/go-jose/v3"
+)
+
+var (
+	ErrNoSigningKey = errors.New("no signing key configured")
+	ErrKeyNotFound  = errors.New("signing key not found in key set")
+)
+
+type UnsupportedKeyError string
+
+func (e UnsupportedKeyError) Error() string {
+	return "unsupported signing key: " + string(e)
+}
+
+// KeyConfig describes a signing key. The key is read from File if it is set,
+// and Secret is used otherwise.
+//
+// A key file may contain a PEM encoded RSA or EC private key, a JWK, a JWK
+// set or, for HMAC algorithms, the raw shared secret. When Algorithm is empty
+// it is inferred from the key.
+type KeyConfig struct {
+	Algorithm string
+	Secret    []byte
+	File      string
+
+	// KeyID is sent in the kid header. It also selects the key from a JWK
+	// set; the first key in the set is used when it is empty.
+	KeyID string
+}
+
+// Load reads the key and returns a signer for it.
+func (kc KeyConfig) Load() (jose.Signer, error) {
+	raw := kc.Secret
+	if kc.File != "" {
+		var err error
+		if raw, err = ioutil.ReadFile(kc.File); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(raw) == 0 {
+		return nil, ErrNoSigningKey
+	}
+
+	key, alg, kid, err := kc.parse(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	if kc.Algorithm != "" {
+		alg = jose.SignatureAlgorithm(kc.Algorithm)
+	}
+
+	if alg == "" {
+		if alg, err = inferAlgorithm(key); err != nil {
+			return nil, err
+		}
+	}
+
+	if kc.KeyID != "" {
+		kid = kc.KeyID
+	}
+
+	opts := (&jose.SignerOptions{}).WithType("JWT")
+	if kid != "" {
+		opts = opts.WithHeader("kid", kid)
+	}
+
+	return jose.NewSigner(jose.SigningKey{Algorithm: alg, Key: key}, opts)
+}
+
+func (kc KeyConfig) parse(raw []byte) (key interface{}, alg jose.SignatureAlgorithm, kid string, err error) {
+	if kc.File == "" {
+		return raw, "", "", nil
+	}
+
+	trimmed := bytes.TrimSpace(raw)
+
+	if bytes.HasPrefix(trimmed, []byte("{")) {
+		jwk, err := kc.parseJWK(trimmed)
+		if err != nil {
+			return nil, "", "", err
+		}
+
+		return jwk.Key, jose.SignatureAlgorithm(jwk.Algorithm), jwk.KeyID, nil
+	}
+
+	if block, _ := pem.Decode(trimmed); block != nil {
+		key, err := parsePrivateKey(block)
+		return key, "", "", err
+	}
+
+	// anything else is taken as a shared secret, minus the trailing newline
+	// most editors leave behind
+	return trimmed, "", "", nil
+}
+
+func (kc KeyConfig) parseJWK(raw []byte) (*jose.JSONWebKey, error) {
+	jwk, err := kc.selectJWK(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	if jwk.IsPublic() {
+		return nil, UnsupportedKeyError("public key")
+	}
+
+	return jwk, nil
+}
+
+// selectJWK returns the key in raw, which is either a single JWK or a JWK set
+// the key is picked from by KeyID.
+func (kc KeyConfig) selectJWK(raw []byte) (*jose.JSONWebKey, error) {
+	var set jose.JSONWebKeySet
+	if err := json.Unmarshal(raw, &set); err == nil && len(set.Keys) > 0 {
+		if kc.KeyID == "" {
+			return &set.Keys[0], nil
+		}
+
+		if keys := set.Key(kc.KeyID); len(keys) > 0 {
+			return &keys[0], nil
+		}
+
+		return nil, ErrKeyNotFound
+	}
+
+	var jwk jose.JSONWebKey
+	if err := json.Unmarshal(raw, &jwk); err != nil {
+		return nil, err
+	}
+
+	return &jwk, nil
+}
+
+func parsePrivateKey(block *pem.Block) (interface{}, error) {
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		return x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+
+	return nil, UnsupportedKeyError(block.Type)
+}
+
+func inferAlgorithm(key interface{}) (jose.SignatureAlgorithm, error) {
+	switch key := key.(type) {
+	case []byte:
+		return jose.HS256, nil
+	case *rsa.PrivateKey:
+		return jose.RS256, nil
+	case *ecdsa.PrivateKey:
+		switch key.Curve {
+		case elliptic.P256():
+			return jose.ES256, nil
+		case elliptic.P384():
+			return jose.ES384, nil
+		case elliptic.P521():
+			return jose.ES512, nil
+		}
+	}
+
+	return "", UnsupportedKeyError("cannot infer algorithm")
+}
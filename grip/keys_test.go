@@ -0,0 +1,256 @@
+package grip
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/square/go-jose/v3"
+	"github.com/square/go-jose/v3/jwt"
+)
+
+func writeKeyFile(t *testing.T, name string, data []byte) string {
+	t.Helper()
+
+	file := filepath.Join(t.TempDir(), name)
+	if err := ioutil.WriteFile(file, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return file
+}
+
+func marshalJSON(t *testing.T, v interface{}) []byte {
+	t.Helper()
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return data
+}
+
+func TestKeyConfigLoad(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ecDER, err := x509.MarshalECPrivateKey(ecKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rsaPKCS8, err := x509.MarshalPKCS8PrivateKey(rsaKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ecPKCS8, err := x509.MarshalPKCS8PrivateKey(ecKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rsaJWK := jose.JSONWebKey{Key: rsaKey, KeyID: "rsa", Algorithm: "PS256"}
+	ecJWK := jose.JSONWebKey{Key: ecKey, KeyID: "ec"}
+
+	set := marshalJSON(t, jose.JSONWebKeySet{Keys: []jose.JSONWebKey{rsaJWK, ecJWK}})
+
+	tests := []struct {
+		name   string
+		config KeyConfig
+		file   []byte
+
+		alg     jose.SignatureAlgorithm
+		kid     string
+		err     error
+		invalid bool
+	}{
+		{
+			name:   "secret",
+			config: KeyConfig{Secret: []byte("secret")},
+			alg:    jose.HS256,
+		},
+		{
+			name:   "secret with algorithm",
+			config: KeyConfig{Secret: []byte("secret"), Algorithm: "HS512", KeyID: "k"},
+			alg:    jose.HS512,
+			kid:    "k",
+		},
+		{
+			name:   "no key",
+			config: KeyConfig{},
+			err:    ErrNoSigningKey,
+		},
+		{
+			name: "secret file",
+			file: []byte("secret\n"),
+			alg:  jose.HS256,
+		},
+		{
+			name: "rsa pkcs1",
+			file: pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)}),
+			alg:  jose.RS256,
+		},
+		{
+			name: "rsa pkcs8",
+			file: pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: rsaPKCS8}),
+			alg:  jose.RS256,
+		},
+		{
+			name: "ec",
+			file: pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: ecDER}),
+			alg:  jose.ES384,
+		},
+		{
+			name: "ec pkcs8",
+			file: pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: ecPKCS8}),
+			alg:  jose.ES384,
+		},
+		{
+			name: "unsupported pem",
+			file: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: []byte{0}}),
+			err:  UnsupportedKeyError("CERTIFICATE"),
+		},
+		{
+			name: "jwk",
+			file: marshalJSON(t, rsaJWK),
+			alg:  jose.PS256,
+			kid:  "rsa",
+		},
+		{
+			name: "jwk set",
+			file: set,
+			alg:  jose.PS256,
+			kid:  "rsa",
+		},
+		{
+			name:   "jwk set by kid",
+			config: KeyConfig{KeyID: "ec"},
+			file:   set,
+			alg:    jose.ES384,
+			kid:    "ec",
+		},
+		{
+			name:   "jwk set without kid",
+			config: KeyConfig{KeyID: "missing"},
+			file:   set,
+			err:    ErrKeyNotFound,
+		},
+		{
+			name: "public jwk",
+			file: marshalJSON(t, ecJWK.Public()),
+			err:  UnsupportedKeyError("public key"),
+		},
+		{
+			name:    "invalid jwk",
+			file:    []byte("{"),
+			invalid: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := test.config
+			if test.file != nil {
+				config.File = writeKeyFile(t, "key", test.file)
+			}
+
+			signer, err := config.Load()
+			if test.err != nil || test.invalid {
+				if err == nil {
+					t.Fatal("expected an error")
+				} else if test.err != nil && err != test.err {
+					t.Fatalf("expected %q, got %q", test.err, err)
+				}
+
+				return
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			jws, err := signer.Sign([]byte("{}"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// the headers are only filled in by parsing
+			compact, err := jws.CompactSerialize()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if jws, err = jose.ParseSigned(compact); err != nil {
+				t.Fatal(err)
+			}
+
+			header := jws.Signatures[0].Header
+			if jose.SignatureAlgorithm(header.Algorithm) != test.alg {
+				t.Errorf("expected algorithm %s, got %s", test.alg, header.Algorithm)
+			}
+
+			if header.KeyID != test.kid {
+				t.Errorf("expected kid %q, got %q", test.kid, header.KeyID)
+			}
+		})
+	}
+}
+
+func TestSignerWatchKey(t *testing.T) {
+	config := KeyConfig{
+		File: writeKeyFile(t, "secret", []byte("old")),
+	}
+
+	s, err := NewSignerFromConfig("gateway", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stop := s.WatchKey(config, time.Millisecond)
+	defer stop()
+
+	if err := ioutil.WriteFile(config.File, []byte("new secret"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		token, err := s.Token()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		parsed, err := jwt.ParseSigned(token)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var claims jwt.Claims
+		if parsed.Claims([]byte("new secret"), &claims) == nil {
+			if claims.Issuer != "gateway" {
+				t.Fatalf("expected issuer gateway, got %q", claims.Issuer)
+			}
+
+			return
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatal("signer still uses the old key")
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+}
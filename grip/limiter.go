@@ -0,0 +1,86 @@
+package grip
+
+import (
+	"context"
+	"errors"
+
+	"go.uber.org/atomic"
+)
+
+var ErrQueueFull = errors.New("origin request queue is full")
+
+// Limiter bounds the number of requests in flight to an origin. Requests
+// beyond the limit wait in a bounded queue and are rejected once it is full.
+type Limiter struct {
+	slots    chan struct{}
+	maxQueue int64
+	queued   atomic.Int64
+	rejected atomic.Uint64
+}
+
+// NewLimiter creates a limiter that allows maxInFlight concurrent requests
+// and up to maxQueue waiting requests.
+func NewLimiter(maxInFlight, maxQueue int) *Limiter {
+	return &Limiter{
+		slots:    make(chan struct{}, maxInFlight),
+		maxQueue: int64(maxQueue),
+	}
+}
+
+// Acquire takes a slot, waiting for one if the limit is reached. It returns
+// ErrQueueFull without waiting if the queue is full.
+func (l *Limiter) Acquire() error {
+	return l.AcquireContext(context.Background())
+}
+
+// AcquireContext is like Acquire, but gives up waiting with the context's
+// error once ctx is done.
+func (l *Limiter) AcquireContext(ctx context.Context) error {
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	default:
+	}
+
+	if l.queued.Inc() > l.maxQueue {
+		l.queued.Dec()
+		l.rejected.Inc()
+		return ErrQueueFull
+	}
+
+	defer l.queued.Dec()
+
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Release returns a slot taken by Acquire.
+func (l *Limiter) Release() {
+	<-l.slots
+}
+
+// InFlight returns the number of requests holding a slot.
+func (l *Limiter) InFlight() int {
+	return len(l.slots)
+}
+
+// Queued returns the number of requests waiting for a slot.
+func (l *Limiter) Queued() int {
+	return int(l.queued.Load())
+}
+
+// Rejected returns the number of requests rejected because the queue was
+// full.
+func (l *Limiter) Rejected() uint64 {
+	return l.rejected.Load()
+}
+
+// Saturated reports whether every slot is taken and the queue is full, so
+// that another request would be rejected.
+func (l *Limiter) Saturated() bool {
+	return len(l.slots) == cap(l.slots) && l.queued.Load() >= l.maxQueue
+}
@@ -0,0 +1,244 @@
+package grip
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math"
+	"strconv"
+
+	"github.com/gobwas/pool/pbufio"
+)
+
+// EventHeader describes an event read by an EventReader.
+type EventHeader struct {
+	Type string
+	Size int64
+}
+
+// EventReader decodes a stream of events without buffering their content.
+// The content of each event is exposed as a reader bounded by the event size,
+// so large payloads may be piped elsewhere as they arrive.
+type EventReader struct {
+	br      *bufio.Reader
+	content contentReader
+	err     error
+
+	maxEventSize int64
+	maxTotalSize int64
+	total        int64
+}
+
+func NewEventReader(r io.Reader) *EventReader {
+	er := &EventReader{
+		br:           pbufio.GetReader(r, readBufferSize),
+		maxEventSize: DefaultMaxEventSize,
+	}
+
+	er.content.er = er
+
+	return er
+}
+
+// Release returns the read buffer to its pool. The EventReader must not be
+// used afterwards.
+func (er *EventReader) Release() {
+	if er.br != nil {
+		pbufio.PutReader(er.br)
+		er.br = nil
+	}
+}
+
+// SetLimits sets the largest content size of a single event and the largest
+// number of bytes read over all events. A limit of zero or less disables it.
+func (er *EventReader) SetLimits(maxEventSize, maxTotalSize int64) {
+	er.maxEventSize = maxEventSize
+	er.maxTotalSize = maxTotalSize
+}
+
+// Next advances to the next event and returns its header along with a reader
+// for its content. Unread content of the previous event is discarded. Done is
+// returned once the stream has ended cleanly, any other error is returned
+// again by subsequent calls.
+func (er *EventReader) Next() (EventHeader, io.Reader, error) {
+	if er.err != nil {
+		return EventHeader{}, nil, er.err
+	}
+
+	h, err := er.next()
+	if err != nil {
+		er.err = err
+		return EventHeader{}, nil, err
+	}
+
+	return h, &er.content, nil
+}
+
+func (er *EventReader) next() (EventHeader, error) {
+	if er.content.remaining > 0 || er.content.trailer {
+		if _, err := io.Copy(ioutil.Discard, &er.content); err != nil {
+			return EventHeader{}, err
+		}
+	}
+
+	line, err := er.readHeader()
+	if err != nil {
+		return EventHeader{}, err
+	}
+
+	var h EventHeader
+	if pos := bytes.IndexByte(line, ' '); pos != -1 {
+		size := line[pos+1:]
+		if h.Size, err = strconv.ParseInt(string(size), 16, 64); err != nil || h.Size < 0 {
+			return EventHeader{}, InvalidContentSizeError(size)
+		}
+
+		line = line[:pos]
+	}
+
+	h.Type = string(line)
+
+	if er.maxEventSize > 0 && h.Size > er.maxEventSize {
+		return EventHeader{}, EventTooLargeError(h.Size)
+	}
+
+	if h.Size > math.MaxInt64-2 {
+		return EventHeader{}, EventTooLargeError(h.Size)
+	}
+
+	if h.Size > 0 {
+		if err := er.account(h.Size + 2); err != nil {
+			return EventHeader{}, err
+		}
+	}
+
+	er.content.typ = h.Type
+	er.content.remaining = h.Size
+	er.content.trailer = h.Size > 0
+	er.content.err = nil
+
+	return h, nil
+}
+
+// readHeader returns the next header line without its trailing CRLF.
+func (er *EventReader) readHeader() ([]byte, error) {
+	var line []byte
+	for {
+		chunk, err := er.br.ReadSlice('\n')
+		line = append(line, chunk...)
+
+		if len(line) > maxHeaderSize+2 {
+			return nil, MalformedHeaderError("header line too long")
+		}
+
+		if err == bufio.ErrBufferFull {
+			continue
+		} else if err == io.EOF {
+			if len(line) > 0 {
+				return nil, TruncatedEventError(strconv.Quote(string(line)))
+			}
+
+			return nil, Done
+		} else if err != nil {
+			return nil, err
+		}
+
+		break
+	}
+
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return nil, MalformedHeaderError(strconv.Quote(string(line)))
+	}
+
+	if err := er.account(int64(len(line))); err != nil {
+		return nil, err
+	}
+
+	return line[:len(line)-2], nil
+}
+
+// account adds n bytes to the total read from the stream. It is checked
+// against what is left of the limit before adding, so that a huge size can't
+// wrap the total around.
+func (er *EventReader) account(n int64) error {
+	if er.maxTotalSize > 0 && n > er.maxTotalSize-er.total {
+		er.total = er.maxTotalSize
+		return StreamTooLargeError(er.maxTotalSize)
+	}
+
+	er.total += n
+
+	return nil
+}
+
+// contentReader reads the content of the current event and verifies the CRLF
+// that follows it.
+type contentReader struct {
+	er        *EventReader
+	typ       string
+	remaining int64
+	trailer   bool
+	err       error
+}
+
+func (cr *contentReader) Read(p []byte) (int, error) {
+	if cr.err != nil {
+		return 0, cr.err
+	}
+
+	if cr.remaining == 0 {
+		cr.err = cr.readTrailer()
+		if cr.err == nil {
+			cr.err = io.EOF
+		}
+
+		return 0, cr.err
+	}
+
+	if int64(len(p)) > cr.remaining {
+		p = p[:cr.remaining]
+	}
+
+	n, err := cr.er.br.Read(p)
+	cr.remaining -= int64(n)
+
+	if err == io.EOF {
+		if cr.remaining > 0 {
+			err = TruncatedEventError(cr.typ)
+		} else {
+			err = nil
+		}
+	}
+
+	if err != nil {
+		cr.err = err
+		cr.er.err = err
+	}
+
+	return n, err
+}
+
+func (cr *contentReader) readTrailer() error {
+	if !cr.trailer {
+		return nil
+	}
+
+	cr.trailer = false
+
+	var crlf [2]byte
+	if _, err := io.ReadFull(cr.er.br, crlf[:]); err == io.EOF || err == io.ErrUnexpectedEOF {
+		cr.er.err = TruncatedEventError(cr.typ)
+		return cr.er.err
+	} else if err != nil {
+		cr.er.err = err
+		return err
+	}
+
+	if !bytes.Equal(crlf[:], eol) {
+		cr.er.err = MalformedContentError(cr.typ)
+		return cr.er.err
+	}
+
+	return nil
+}
@@ -1,27 +1,156 @@
 package grip
 
 import (
+	"log"
+	"os"
+	"sync"
 	"time"
 
 	"github.com/square/go-jose/v3"
 	"github.com/square/go-jose/v3/jwt"
 )
 
+// DefaultTokenLifetime is how long a signature produced by Token is valid
+// for unless changed with SetLifetime.
+const DefaultTokenLifetime = time.Hour
+
 type Signer struct {
 	issuer string
-	signer jose.Signer
+
+	mu       sync.RWMutex
+	signer   jose.Signer
+	lifetime time.Duration
+	claims   map[string]interface{}
 }
 
 func NewSigner(issuer string, signer jose.Signer) *Signer {
 	return &Signer{
-		issuer: issuer,
-		signer: signer,
+		issuer:   issuer,
+		signer:   signer,
+		lifetime: DefaultTokenLifetime,
+	}
+}
+
+// NewSignerFromConfig loads the key described by kc and returns a signer
+// that uses it.
+func NewSignerFromConfig(issuer string, kc KeyConfig) (*Signer, error) {
+	signer, err := kc.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	return NewSigner(issuer, signer), nil
+}
+
+// SetSigner replaces the underlying key. Tokens signed after it returns use
+// the new key.
+func (s *Signer) SetSigner(signer jose.Signer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.signer = signer
+}
+
+// SetLifetime sets how long tokens produced by Token are valid for.
+func (s *Signer) SetLifetime(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lifetime = d
+}
+
+// SetClaim adds an extra claim to every token. The iss and exp claims are
+// always set by the signer and cannot be overridden.
+func (s *Signer) SetClaim(name string, value interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.claims == nil {
+		s.claims = map[string]interface{}{}
+	}
+
+	s.claims[name] = value
+}
+
+// Token signs a token that expires after the configured lifetime.
+func (s *Signer) Token() (string, error) {
+	s.mu.RLock()
+	lifetime := s.lifetime
+	s.mu.RUnlock()
+
+	return s.Sign(time.Now().Add(lifetime))
+}
+
+func (s *Signer) Sign(exp time.Time) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	claims := make(map[string]interface{}, len(s.claims)+2)
+	for k, v := range s.claims {
+		claims[k] = v
 	}
+
+	claims["iss"] = s.issuer
+	claims["exp"] = exp.Unix()
+
+	return jwt.Signed(s.signer).Claims(claims).CompactSerialize()
 }
 
-func (s Signer) Sign(exp time.Time) (string, error) {
-	return jwt.Signed(s.signer).Claims(map[string]interface{}{
-		"iss": s.issuer,
-		"exp": exp.Unix(),
-	}).CompactSerialize()
+// WatchKey polls the key file described by kc every interval and swaps in
+// the new key whenever the file changes. A key that fails to load is logged
+// and the previous key is kept. Calling the returned function stops the
+// watcher.
+func (s *Signer) WatchKey(kc KeyConfig, interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+
+	var once sync.Once
+	stop = func() {
+		once.Do(func() {
+			close(done)
+		})
+	}
+
+	if kc.File == "" {
+		return stop
+	}
+
+	// changes are detected from the moment WatchKey returns
+	last, _ := os.Stat(kc.File)
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+
+			info, err := os.Stat(kc.File)
+			if err != nil {
+				log.Println("# failed to stat signing key:", err)
+				continue
+			}
+
+			if last != nil && info.ModTime().Equal(last.ModTime()) && info.Size() == last.Size() {
+				continue
+			}
+
+			last = info
+
+			signer, err := kc.Load()
+			if err != nil {
+				log.Println("# failed to reload signing key:", err)
+				continue
+			}
+
+			s.SetSigner(signer)
+
+			log.Println("# reloaded signing key from", kc.File)
+		}
+	}()
+
+	return stop
 }
@@ -1,12 +1,15 @@
 package grip
 
 import (
-	"bytes"
+	"compress/gzip"
+	"io"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
-	"strings"
+	"sync"
 	"time"
+
+	"go.uber.org/atomic"
 )
 
 type Transport interface {
@@ -14,106 +17,358 @@ type Transport interface {
 
 	ForwardRequest(w http.ResponseWriter, r *http.Request)
 
-	sendEvents(path string, connectionID string, e []Event) (http.Header, []Event, error)
+	// Saturated reports whether the origin is too busy to take on new
+	// connections.
+	Saturated() bool
+
+	sendEvents(c *Connection, e []Event, handle func(Event) error) (http.Header, error)
+	startEvents(c *Connection, e []Event, handle func(Event) error, done func(error)) (http.Header, error)
 }
 
 type HTTPTransport struct {
+	// MaxEventSize is the largest event content accepted from the origin.
+	MaxEventSize int64
+
+	// MaxResponseSize is the largest number of bytes read from a single
+	// response. Zero means no limit, which suits origins that hold the
+	// response open.
+	MaxResponseSize int64
+
+	// CompressThreshold is the smallest request body that is sent gzip
+	// compressed. Zero or less disables compression of requests, responses
+	// are decoded either way.
+	CompressThreshold int
+
+	// CompressLevel is the gzip level used for request bodies.
+	CompressLevel int
+
+	// BatchWindow enables the batch protocol when greater than zero. Events of
+	// all connections on the same path are collected for up to BatchWindow and
+	// sent to the origin in a single multipart request, see ContentTypeBatch.
+	BatchWindow time.Duration
+
+	// Streaming marks an origin that may hold responses open to push events
+	// over time. Client events that follow go out in a new request once the
+	// response has started, see Connection.StartEvents. The batch protocol is
+	// not used for such origins, since a part held open would hold up every
+	// connection after it in the batch.
+	Streaming bool
+
+	// MaxBatchSize is the largest number of connections in a batch. Zero
+	// means no limit.
+	MaxBatchSize int
+
+	// Limiter bounds the number of concurrent requests to the origin. It may
+	// be shared by transports to the same origin.
+	Limiter *Limiter
+
+	// Budget accounts the content of events read from the origin until they
+	// have been handled. It may be shared with the gateway.
+	Budget *Budget
+
+	// Codec encodes the events sent to the origin. Responses are decoded
+	// according to their Content-Type and fall back to Codec.
+	Codec Codec
+
 	endpoint string
 	signer   *Signer
+	client   *http.Client
 	proxy    http.Handler
+
+	batcherOnce sync.Once
+	batcher     *batcher
 }
 
+// NewHTTPTransport creates a transport that talks to the origin at endpoint.
+// The endpoint is either an http(s) url or a unix domain socket in the form
+// unix:///path/to/socket:/base/path.
 func NewHTTPTransport(endpoint string, signer *Signer) (*HTTPTransport, error) {
+	var rt http.RoundTripper = http.DefaultTransport
+	if socket, httpEndpoint, ok := parseUnixEndpoint(endpoint); ok {
+		endpoint = httpEndpoint
+		rt = newUnixRoundTripper(socket)
+	}
+
 	u, err := url.Parse(endpoint)
 	if err != nil {
 		return nil, err
 	}
 
+	proxy := httputil.NewSingleHostReverseProxy(u)
+	proxy.Transport = rt
+
 	return &HTTPTransport{
+		MaxEventSize: DefaultMaxEventSize,
+		Codec:        EventsCodec,
+
+		CompressLevel: gzip.DefaultCompression,
+
 		endpoint: endpoint,
 		signer:   signer,
-		proxy:    httputil.NewSingleHostReverseProxy(u),
+		client:   &http.Client{Transport: rt},
+		proxy:    proxy,
 	}, nil
 }
 
 func (t *HTTPTransport) NewConnection(path string, id string) *Connection {
-	return &Connection{
-		transport: t,
-		path:      path,
-		id:        id,
-	}
+	return newConnection(t, path, id)
 }
 
 func (t *HTTPTransport) ForwardRequest(w http.ResponseWriter, r *http.Request) {
 	t.proxy.ServeHTTP(w, r)
 }
 
-func (t *HTTPTransport) sendEvents(path string, connectionID string, outgoingEvents []Event) (http.Header, []Event, error) {
-	var buf bytes.Buffer
-	for _, event := range outgoingEvents {
-		if err := WriteEvent(&buf, event); err != nil {
-			return nil, nil, err
+func (t *HTTPTransport) Saturated() bool {
+	return t.Limiter != nil && t.Limiter.Saturated()
+}
+
+// sendEvents posts the outgoing events to the origin and passes each event in
+// the response to handle as soon as it has been parsed, so an origin may hold
+// the response open and push events over time.
+func (t *HTTPTransport) sendEvents(c *Connection, outgoingEvents []Event, handle func(Event) error) (http.Header, error) {
+	if t.BatchWindow > 0 && !t.Streaming {
+		return t.getBatcher().submit(c, outgoingEvents, handle)
+	}
+
+	res, done, err := t.post(c, outgoingEvents)
+	if err != nil {
+		return nil, err
+	}
+
+	defer done()
+	defer res.Body.Close()
+
+	return res.Header, t.readResponse(c, res, handle)
+}
+
+// startEvents is like sendEvents, except that for streaming origins it
+// returns once the response has started and reads the rest of it on its own
+// goroutine. done is called when the response has ended, unless an error is
+// returned.
+func (t *HTTPTransport) startEvents(c *Connection, outgoingEvents []Event, handle func(Event) error, done func(error)) (http.Header, error) {
+	if !t.Streaming {
+		h, err := t.sendEvents(c, outgoingEvents, handle)
+		if err == nil {
+			done(nil)
 		}
+
+		return h, err
 	}
 
-	req, err := http.NewRequest(http.MethodPost, t.endpoint+path, &buf)
+	res, release, err := t.post(c, outgoingEvents)
 	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		err := t.readResponse(c, res, handle)
+		res.Body.Close()
+		release()
+		done(err)
+	}()
+
+	return res.Header, nil
+}
+
+// post sends the events of c to the origin in a request that is cancelled
+// when c is closed. A response with a status other than 2xx is an error. The
+// returned function must be called once the response body has been closed.
+func (t *HTTPTransport) post(c *Connection, outgoingEvents []Event) (*http.Response, func(), error) {
+	body := newBodyBuffer()
+	if err := t.Codec.WriteEvents(body, outgoingEvents); err != nil {
+		body.release()
 		return nil, nil, err
 	}
 
-	req.Header.Add("Connection-Id", connectionID)
-	req.Header.Add("Content-Type", "application/websocket-events")
+	req, done, err := t.newRequest(c.path, t.Codec.ContentType(), body)
+	if err != nil {
+		return nil, nil, err
+	}
 
-	if t.signer != nil {
-		sig, err := t.signer.Sign(time.Now().Add(time.Hour))
+	req = req.WithContext(c.context())
+	req.Header.Add("Connection-Id", c.id)
+	c.writeMetaHeaders(req.Header)
+
+	res, err := t.do(req)
+	if err != nil {
+		done()
+		return nil, nil, err
+	}
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		res.Body.Close()
+		done()
+		return nil, nil, UnexpectedStatusError(res.StatusCode)
+	}
+
+	return res, done, nil
+}
+
+// readResponse applies the meta headers of res and passes the events in its
+// body to handle. The events are not read once c is closed, since nothing is
+// left to handle them.
+func (t *HTTPTransport) readResponse(c *Connection, res *http.Response, handle func(Event) error) error {
+	c.applyMetaHeaders(res.Header)
+
+	if c.closed.Load() {
+		return nil
+	}
+
+	r, err := decodedBody(res)
+	if err != nil {
+		return err
+	}
+
+	return t.readEvents(res.Header, r, handle)
+}
+
+// newRequest builds a signed request to the origin, compressing body if it
+// is large enough. The request takes over body, which must come from
+// newBodyBuffer. The returned done must be called once the response body has
+// been closed, or the request has failed.
+func (t *HTTPTransport) newRequest(path string, contentType string, body *bodyBuffer) (*http.Request, func(), error) {
+	compressed := t.CompressThreshold > 0 && body.Len() >= t.CompressThreshold
+	if compressed {
+		zbody, err := gzipBody(body, t.CompressLevel)
+		body.release()
 		if err != nil {
 			return nil, nil, err
 		}
 
-		req.Header.Add("Grip-Sig", sig)
+		body = zbody
 	}
 
-	res, err := http.DefaultClient.Do(req)
+	req, err := http.NewRequest(http.MethodPost, t.endpoint+path, &body.Buffer)
 	if err != nil {
+		body.release()
 		return nil, nil, err
 	}
 
-	newMetadata := map[string]string{}
+	done := func() {}
+	if req.ContentLength == 0 {
+		body.release()
+	} else {
+		// the buffer is gone once the request is done, so it can't be
+		// replayed
+		rb := &requestBody{bodyBuffer: body}
+		rb.refs.Store(2)
+
+		req.GetBody = nil
+		req.Body = rb
+		done = rb.done
+	}
+
+	if compressed {
+		req.Header.Add("Content-Encoding", "gzip")
+	}
+
+	req.Header.Add("Accept-Encoding", acceptEncoding)
+	req.Header.Add("Content-Type", contentType)
+	req.Header.Add("Accept", ContentTypeEvents+", "+ContentTypeEventsJSON)
 
-	for k, vs := range res.Header {
-		if len(k) <= 9 || !strings.HasPrefix(k, "Set-Meta-") {
-			continue
+	if t.signer != nil {
+		sig, err := t.signer.Token()
+		if err != nil {
+			done()
+			return nil, nil, err
 		}
 
-		for _, v := range vs {
-			// last one takes precidence
-			newMetadata[k[:9]] = v
+		req.Header.Add("Grip-Sig", sig)
+	}
+
+	return req, done, nil
+}
+
+// requestBody is the body of a request to the origin. Its buffer is put back
+// once net/http has closed the body and the caller is done with the
+// response, since the transport may still read or close the body after
+// RoundTrip has returned.
+type requestBody struct {
+	*bodyBuffer
+	refs   atomic.Int32
+	closed atomic.Bool
+}
+
+func (b *requestBody) Close() error {
+	if b.closed.CAS(false, true) {
+		b.done()
+	}
+
+	return nil
+}
+
+func (b *requestBody) done() {
+	if b.refs.Dec() == 0 {
+		b.bodyBuffer.release()
+	}
+}
+
+// do sends req once the limiter allows it, or until its context is done. The
+// slot is held until the response body is closed.
+func (t *HTTPTransport) do(req *http.Request) (*http.Response, error) {
+	if t.Limiter == nil {
+		return t.client.Do(req)
+	}
+
+	if err := t.Limiter.AcquireContext(req.Context()); err != nil {
+		// like Do, close the body the request is not sent with
+		if req.Body != nil {
+			req.Body.Close()
 		}
+
+		return nil, err
 	}
 
-	defer res.Body.Close()
+	res, err := t.client.Do(req)
+	if err != nil {
+		t.Limiter.Release()
+		return nil, err
+	}
+
+	res.Body = &releaseOnClose{ReadCloser: res.Body, release: t.Limiter.Release}
+
+	return res, nil
+}
+
+// readEvents decodes the events in r according to the content type in h and
+// passes each of them to handle.
+func (t *HTTPTransport) readEvents(h http.Header, r io.Reader, handle func(Event) error) error {
+	codec, ok := CodecForContentType(h.Get("Content-Type"))
+	if !ok {
+		codec = t.Codec
+	}
 
-	var incomingEvents []Event
-	for it := NewEventIterator(res.Body); ; {
+	it := codec.NewIterator(r, t.MaxEventSize, t.MaxResponseSize)
+	if r, ok := it.(interface{ Release() }); ok {
+		defer r.Release()
+	}
+
+	for {
 		event, err := it.Next()
 		if err == Done {
-			break
+			return nil
 		} else if err != nil {
-			return res.Header, nil, err
+			return err
 		}
 
-		incomingEvents = append(incomingEvents, event)
-	}
+		size := len(event.Content())
+		t.Budget.Add(size)
+		err = handle(event)
+		t.Budget.Release(size)
 
-	return res.Header, incomingEvents, nil
+		if err != nil {
+			return err
+		}
+	}
 }
 
-type Connection struct {
-	transport Transport
-	path      string
-	id        string
+type releaseOnClose struct {
+	io.ReadCloser
+	release func()
+	once    sync.Once
 }
 
-func (c *Connection) SendEvents(e ...Event) (http.Header, []Event, error) {
-	return c.transport.sendEvents(c.path, c.id, e)
+func (r *releaseOnClose) Close() error {
+	r.once.Do(r.release)
+	return r.ReadCloser.Close()
 }
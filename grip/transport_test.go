@@ -0,0 +1,173 @@
+package grip
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// newStreamingOrigin returns a transport to an origin that holds the
+// response to OPEN open until the request is cancelled, and answers TEXT
+// events with the same message prefixed with "m:". The types of the events
+// it receives are sent to received and the cancellation of a held response
+// to cancelled.
+func newStreamingOrigin(t *testing.T) (transport *HTTPTransport, received chan string, cancelled chan struct{}) {
+	received = make(chan string, 16)
+	cancelled = make(chan struct{}, 1)
+
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", ContentTypeEvents)
+
+		var events []Event
+		for it := NewEventIterator(r.Body); ; {
+			event, err := it.Next()
+			if err != nil {
+				break
+			}
+
+			received <- event.Type()
+			events = append(events, event)
+		}
+
+		for _, event := range events {
+			switch event.Type() {
+			case EventTypeOpen:
+				_ = WriteEvent(w, OpenEvent)
+				w.(http.Flusher).Flush()
+
+				<-r.Context().Done()
+				cancelled <- struct{}{}
+				return
+
+			case EventTypeText:
+				_ = WriteEvent(w, NewTextEvent("m:"+string(event.Content())))
+			}
+		}
+	}))
+
+	t.Cleanup(origin.Close)
+
+	transport, err := NewHTTPTransport(origin.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	transport.Streaming = true
+
+	return transport, received, cancelled
+}
+
+func expectEvent(t *testing.T, events chan Event, typ string, content string) {
+	t.Helper()
+
+	select {
+	case event := <-events:
+		if event.Type() != typ || string(event.Content()) != content {
+			t.Fatalf("expected %s %q, got %s %q", typ, content, event.Type(), event.Content())
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for %s %q", typ, content)
+	}
+}
+
+func expectReceived(t *testing.T, received chan string, typ string) {
+	t.Helper()
+
+	select {
+	case got := <-received:
+		if got != typ {
+			t.Fatalf("expected the origin to receive %s, got %s", typ, got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for the origin to receive %s", typ)
+	}
+}
+
+func TestStartEventsWhileResponseOpen(t *testing.T) {
+	transport, received, cancelled := newStreamingOrigin(t)
+	c := transport.NewConnection("/", "test")
+
+	events := make(chan Event, 16)
+	handle := func(event Event) error {
+		events <- event
+		return nil
+	}
+
+	opened := make(chan error, 1)
+	if _, err := c.StartEvents(handle, func(err error) { opened <- err }, OpenEvent); err != nil {
+		t.Fatal(err)
+	}
+
+	expectReceived(t, received, EventTypeOpen)
+	expectEvent(t, events, EventTypeOpen, "")
+
+	// the held response doesn't hold up the next request
+	answered := make(chan error, 1)
+	if _, err := c.StartEvents(handle, func(err error) { answered <- err }, NewTextEvent("hello")); err != nil {
+		t.Fatal(err)
+	}
+
+	expectReceived(t, received, EventTypeText)
+	expectEvent(t, events, EventTypeText, "m:hello")
+
+	if err := <-answered; err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err := <-opened:
+		t.Fatalf("held response ended early: %v", err)
+	default:
+	}
+
+	c.Close()
+
+	select {
+	case <-cancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("held response was not cancelled")
+	}
+
+	if err := <-opened; !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the held response to end with %v, got %v", context.Canceled, err)
+	}
+
+	// requests made after Close still reach the origin
+	_, incoming, err := c.SendEvents(DisconnectEvent)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectReceived(t, received, EventTypeDisconnection)
+
+	if len(incoming) != 0 {
+		t.Fatalf("expected no events after Close, got %d", len(incoming))
+	}
+}
+
+func TestLimiterAcquireContext(t *testing.T) {
+	l := NewLimiter(1, 1)
+	if err := l.Acquire(); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if err := l.AcquireContext(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("expected %v, got %v", context.DeadlineExceeded, err)
+	}
+
+	if l.Queued() != 0 {
+		t.Fatalf("expected no queued requests, got %d", l.Queued())
+	}
+
+	l.Release()
+
+	if err := l.AcquireContext(ctx); err != nil {
+		t.Fatalf("expected a free slot to be taken despite the done context, got %v", err)
+	}
+}
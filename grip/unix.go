@@ -0,0 +1,45 @@
+package grip
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// unixScheme is the scheme prefix of endpoints served over a unix domain
+// socket. The socket path is separated from the request path by a colon, so
+// unix:///run/app.sock:/ws sends requests for /chat to /ws/chat over
+// /run/app.sock.
+const unixScheme = "unix://"
+
+// unixHost is the placeholder host used in request urls that are sent over a
+// unix domain socket.
+const unixHost = "unix"
+
+// parseUnixEndpoint splits a unix endpoint into the socket path and an http
+// endpoint that may be used to build requests.
+func parseUnixEndpoint(endpoint string) (socket string, httpEndpoint string, ok bool) {
+	if !strings.HasPrefix(endpoint, unixScheme) {
+		return "", "", false
+	}
+
+	socket = endpoint[len(unixScheme):]
+
+	var path string
+	if pos := strings.IndexByte(socket, ':'); pos != -1 {
+		socket, path = socket[:pos], socket[pos+1:]
+	}
+
+	return socket, "http://" + unixHost + strings.TrimSuffix(path, "/"), true
+}
+
+func newUnixRoundTripper(socket string) http.RoundTripper {
+	var dialer net.Dialer
+	return &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, "unix", socket)
+		},
+		MaxIdleConnsPerHost: 100,
+	}
+}
@@ -1,7 +1,6 @@
 package grip
 
 import (
-	"bytes"
 	"io"
 	"strconv"
 )
@@ -9,18 +8,59 @@ import (
 var eol = []byte{13, 10}
 
 func WriteEvent(w io.Writer, e Event) error {
-	var buf bytes.Buffer
-	buf.WriteString(e.Type())
-
-	if content := e.Content(); len(content) > 0 {
-		buf.WriteByte(' ')
-		buf.WriteString(strconv.FormatInt(int64(len(content)), 16))
-		buf.Write(eol)
-		buf.Write(content)
+	content := e.Content()
+	if err := WriteEventHeader(w, e.Type(), int64(len(content))); err != nil {
+		return err
 	}
 
-	buf.Write(eol)
+	if len(content) == 0 {
+		return nil
+	}
+
+	if _, err := w.Write(content); err != nil {
+		return err
+	}
+
+	_, err := w.Write(eol)
+	return err
+}
+
+// WriteEventHeader writes the header line of an event with size bytes of
+// content. The content and its trailing CRLF must be written next, which
+// WriteEventFrom takes care of.
+func WriteEventHeader(w io.Writer, typ string, size int64) error {
+	header := make([]byte, 0, len(typ)+1+16+len(eol))
+	header = append(header, typ...)
+
+	if size > 0 {
+		header = append(header, ' ')
+		header = strconv.AppendInt(header, size, 16)
+	}
+
+	header = append(header, eol...)
+
+	_, err := w.Write(header)
+	return err
+}
+
+// WriteEventFrom writes an event whose content is streamed from r. Exactly
+// size bytes are copied from r, io.ErrUnexpectedEOF is returned if r ends
+// early.
+func WriteEventFrom(w io.Writer, typ string, size int64, r io.Reader) error {
+	if err := WriteEventHeader(w, typ, size); err != nil {
+		return err
+	}
+
+	if size == 0 {
+		return nil
+	}
+
+	if n, err := io.CopyN(w, r, size); err == io.EOF || (err == nil && n < size) {
+		return io.ErrUnexpectedEOF
+	} else if err != nil {
+		return err
+	}
 
-	_, err := buf.WriteTo(w)
+	_, err := w.Write(eol)
 	return err
 }
@@ -2,38 +2,139 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"log"
 	"net"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/gobwas/ws"
-	"github.com/mailru/easygo/netpoll"
 	"github.com/ssttevee/go-wsproxy/gateway"
 	"github.com/ssttevee/go-wsproxy/grip"
+	gopool "github.com/ssttevee/go-wsproxy/pool"
 )
 
 var (
 	addr      = flag.String("listen", ":8080", "address to bind to")
-	ioTimeout = flag.Duration("io_timeout", time.Millisecond*100, "i/o operations timeout")
+	debugAddr = flag.String("debug_listen", "", "address to serve /debug/vars metrics on, empty to disable")
+	pubAddr   = flag.String("publish_listen", "", "address to accept publish requests on, empty to disable")
+	origin    = flag.String("origin", "http://localhost:12345", "origin url, or unix:///path/to/socket:/base/path for a unix domain socket")
+	ioModel   = flag.String("io_model", "netpoll", "how client connections are served: netpoll, or goroutine for a reader and writer goroutine per connection")
+	ioTimeout = flag.Duration("io_timeout", time.Millisecond*100, "deadline of each write to a client, 0 for no limit")
+
+	originMaxInFlight = flag.Int("origin_max_inflight", 0, "largest number of concurrent requests to each origin, 0 for no limit")
+	originMaxQueue    = flag.Int("origin_max_queue", 1024, "largest number of requests waiting for an origin, new connections are refused with 503 when full")
+
+	defaultCodec      = flag.String("codec", "events", "encoding of events sent to the origin, events or json")
+	maxEventSize      = flag.Int64("max_event_size", grip.DefaultMaxEventSize, "largest event content accepted from the origin")
+	maxResponseSize   = flag.Int64("max_response_size", 0, "largest origin response in bytes, 0 for no limit")
+	compressThreshold = flag.Int("compress_threshold", 0, "gzip compress origin request bodies of at least this many bytes, 0 to disable")
+	batchWindow       = flag.Duration("batch_window", 0, "collect events of many connections for this long and send them to the origin in one request, 0 to disable")
+	streaming         = flag.Bool("streaming", false, "the origin may hold responses open to push events over time, which disables batch_window")
+	maxBatchSize      = flag.Int("max_batch_size", 0, "largest number of connections in a batched request, 0 for no limit")
+
+	eventLinger        = flag.Duration("linger", 0, "collect client events for up to this long before sending them to the origin")
+	maxEventBatch      = flag.Int("batch_events", 0, "largest number of client events sent to the origin in one request, 0 for no limit")
+	maxEventBatchBytes = flag.Int("batch_bytes", 0, "largest content size of client events sent to the origin in one request, 0 for no limit")
+
+	pingInterval   = flag.Duration("ping_interval", 0, "how often to ping clients to check they are alive, 0 to disable")
+	maxMissedPongs = flag.Int("max_missed_pongs", 3, "drop clients that leave this many consecutive pings unanswered, 0 to never drop")
+	pingPolicy     = flag.String("ping", "forward", "handling of client pings: forward to the origin, answer locally and forward, or answer locally only (forward, answer or local)")
+
+	idleTimeout    = flag.Duration("idle_timeout", 0, "close connections whose client sent no data for this long, 0 to disable")
+	maxLifetime    = flag.Duration("max_lifetime", 0, "close connections after this long, 0 to disable")
+	lifetimeJitter = flag.Duration("lifetime_jitter", 0, "random duration of up to this long added to max_lifetime")
+
+	maxQueuedMessages  = flag.Int("max_queued", 0, "largest number of messages queued for a client, 0 for no limit")
+	maxQueuedBytes     = flag.Int("max_queued_bytes", 0, "largest payload size queued for a client, 0 for no limit")
+	slowConsumerPolicy = flag.String("slow_consumer", "drop-oldest", "what to do when a client's queue is full: drop-oldest, drop-newest or disconnect")
+
+	workers            = flag.Int("workers", gateway.DefaultWorkers, "largest number of goroutines doing connection i/o")
+	workerQueue        = flag.Int("worker_queue", gateway.DefaultWorkerQueue, "largest number of tasks waiting for a worker")
+	backendWorkers     = flag.Int("backend_workers", gateway.DefaultBackendWorkers, "largest number of goroutines sending client events to origins")
+	backendWorkerQueue = flag.Int("backend_worker_queue", gateway.DefaultBackendQueue, "largest number of send loops waiting for a backend worker")
+	scheduleTimeout    = flag.Duration("schedule_timeout", gateway.DefaultScheduleTimeout, "how long work waits for a worker before reads, writes and sends are retried later")
+
+	memoryBudget = flag.Int64("memory_budget", 0, "bytes held in client queues, pending events and origin responses before new connections are refused, reading is paused and the largest queues are shed, 0 for no limit")
+
+	sigIssuer    = flag.String("sig_iss", "", "issuer claim of the Grip-Sig token")
+	sigKey       = flag.String("sig_key", "", "shared secret used to sign the Grip-Sig token")
+	sigKeyFile   = flag.String("sig_key_file", "", "PEM, JWK, JWK set or shared secret file used to sign the Grip-Sig token")
+	sigAlg       = flag.String("sig_alg", "", "signing algorithm, e.g. HS256, RS256 or ES256 (inferred from the key when empty)")
+	sigKeyID     = flag.String("sig_kid", "", "kid header of the Grip-Sig token, also selects the key from a JWK set")
+	sigLifetime  = flag.Duration("sig_lifetime", grip.DefaultTokenLifetime, "lifetime of the Grip-Sig token")
+	sigKeyReload = flag.Duration("sig_key_reload", 10*time.Second, "how often to check the key file for changes, 0 to disable")
+	sigClaims    = claimsFlag{}
+
+	routes routesFlag
 )
 
+func init() {
+	flag.Var(&routes, "route", "route in the form `prefix=origin;option=value`, may be repeated (options: codec, streaming, linger, batch_events, batch_bytes, ping, ping_interval, max_missed_pongs, idle_timeout, max_lifetime, lifetime_jitter, max_queued, max_queued_bytes, slow_consumer)")
+	flag.Var(sigClaims, "sig_claim", "extra `name=value` claim added to the Grip-Sig token, may be repeated")
+}
+
 func main() {
 	flag.Parse()
 
-	// Initialize netpoll instance. We will use it to be noticed about incoming
-	// events from listener of user connections.
-	poller, err := netpoll.New(nil)
+	if *memoryBudget > 0 {
+		budget = grip.NewBudget(*memoryBudget)
+	}
+
+	signer, err := newSigner()
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	transport, err := grip.NewHTTPTransport("http://localhost:12345", nil)
+	defaultRoute, err := (&routeSpec{origin: *origin}).route(signer)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	chat := gateway.New(transport)
+	chat := gateway.New(defaultRoute.Transport)
+	chat.Budget = budget
+	chat.Pool = gopool.NewPool(*workers, *workerQueue, 1)
+	chat.BackendPool = gopool.NewPool(*backendWorkers, *backendWorkerQueue, 1)
+	chat.ScheduleTimeout = *scheduleTimeout
+	chat.WriteTimeout = *ioTimeout
+
+	switch *ioModel {
+	case "netpoll":
+		// Initialize netpoll instance. We will use it to be noticed about
+		// incoming events from listener of user connections.
+		if chat.IOModel, err = gateway.NewNetpollIO(); err != nil {
+			log.Fatal(err)
+		}
+	case "goroutine":
+		chat.IOModel = gateway.GoroutineIO{}
+	default:
+		log.Fatalf("unknown i/o model %q", *ioModel)
+	}
+	chat.AddRoute(defaultRoute)
+
+	for _, spec := range routes {
+		route, err := spec.route(signer)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		chat.AddRoute(route)
+	}
+
+	if *debugAddr != "" {
+		publishMetrics(chat)
+
+		go func() {
+			log.Fatal(http.ListenAndServe(*debugAddr, nil))
+		}()
+	}
+
+	if *pubAddr != "" {
+		go func() {
+			log.Fatal(http.ListenAndServe(*pubAddr, chat.PublishHandler()))
+		}()
+	}
 
 	// Create incoming connections listener.
 	lis, err := net.Listen("tcp", *addr)
@@ -45,63 +146,69 @@ func main() {
 
 	http.Serve(lis, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Header.Get("Connection") == "Upgrade" && r.Header.Get("Upgrade") == "websocket" {
+			if !chat.Accepting(r.URL.Path) {
+				http.Error(w, "origin is busy", http.StatusServiceUnavailable)
+				return
+			}
+
 			conn, _, _, err := ws.UpgradeHTTP(r, w)
 			if err != nil {
-				log.Printf("# %s: upgrade error: %v", nameConn(conn), err)
+				log.Printf("# %s: upgrade error: %v", r.RemoteAddr, err)
 				return
 			}
 
-			// Create netpoll event descriptor for conn.
-			// We want to handle only read events of it.
-			desc := netpoll.Must(netpoll.HandleReadWrite(conn))
-
-			// Register incoming user in chat.
-			user := chat.NewConnection(r.URL.Path, conn, func() {
-				log.Printf("# %s: dropped", nameConn(conn))
-				poller.Stop(desc)
-			})
-
-			// Subscribe to events about conn.
-			poller.Start(desc, func(ev netpoll.Event) {
-				log.Println(ev)
-
-				if ev&(netpoll.EventReadHup|netpoll.EventHup) != 0 {
-					// When ReadHup or Hup received, this mean that client has
-					// closed at least write end of the connection or connections
-					// itself. So we want to stop receive events about such conn
-					// and remove it from the chat registry.
-					user.Drop()
-					return
-				}
-
-				if ev&netpoll.EventRead != 0 {
-					go func() {
-						if err := user.Receive(); err != nil {
-							log.Println("# receive error:", err)
-							// When receive failed, we can only disconnect broken
-							// connection and stop to receive events about it.
-							user.Drop()
-						}
-					}()
-				}
-
-				if ev&netpoll.EventWrite != 0 {
-					go func() {
-						if err := user.Transmit(); err != nil {
-							log.Println("# transmit error:", err)
-							// When receive failed, we can only disconnect broken
-							// connection and stop to receive events about it.
-							user.Drop()
-						}
-					}()
-				}
-			})
+			if _, err := chat.Accept(r.URL.Path, conn); err != nil {
+				log.Printf("# %s: accept error: %v", conn.RemoteAddr(), err)
+				conn.Close()
+			}
 		} else {
 			chat.Forward(w, r)
 		}
 	}))
 }
 
-func nameConn(conn net.Conn) string {
-	return conn.LocalAddr().String() + " > " + conn.RemoteAddr().String()
+func newSigner() (*grip.Signer, error) {
+	if *sigKey == "" && *sigKeyFile == "" {
+		return nil, nil
+	}
+
+	kc := grip.KeyConfig{
+		Algorithm: *sigAlg,
+		Secret:    []byte(*sigKey),
+		File:      *sigKeyFile,
+		KeyID:     *sigKeyID,
+	}
+
+	signer, err := grip.NewSignerFromConfig(*sigIssuer, kc)
+	if err != nil {
+		return nil, err
+	}
+
+	signer.SetLifetime(*sigLifetime)
+
+	for name, value := range sigClaims {
+		signer.SetClaim(name, value)
+	}
+
+	if *sigKeyReload > 0 {
+		signer.WatchKey(kc, *sigKeyReload)
+	}
+
+	return signer, nil
+}
+
+type claimsFlag map[string]string
+
+func (f claimsFlag) String() string {
+	return ""
+}
+
+func (f claimsFlag) Set(s string) error {
+	pos := strings.IndexByte(s, '=')
+	if pos < 1 {
+		return fmt.Errorf("expected name=value but got %q", s)
+	}
+
+	f[s[:pos]] = s[pos+1:]
+	return nil
 }
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"expvar"
+	"time"
+
+	"github.com/ssttevee/go-wsproxy/gateway"
+)
+
+// publishMetrics exposes the gateway's metrics through expvar.
+func publishMetrics(gw *gateway.Gateway) {
+	expvar.Publish("connections", expvar.Func(func() interface{} {
+		return gw.Count()
+	}))
+
+	expvar.Publish("rtt", expvar.Func(func() interface{} {
+		// connections without a measurement yet are left out
+		var n int
+		var sum, max time.Duration
+		for _, c := range gw.Connections() {
+			rtt := c.RTT()
+			if rtt <= 0 {
+				continue
+			}
+
+			n++
+			sum += rtt
+			if rtt > max {
+				max = rtt
+			}
+		}
+
+		var avg time.Duration
+		if n > 0 {
+			avg = sum / time.Duration(n)
+		}
+
+		return map[string]interface{}{
+			"measured": n,
+			"avg_ms":   avg.Seconds() * 1000,
+			"max_ms":   max.Seconds() * 1000,
+		}
+	}))
+
+	expvar.Publish("memory", expvar.Func(func() interface{} {
+		return map[string]interface{}{
+			"used": gw.Budget.Used(),
+			"max":  gw.Budget.Max(),
+		}
+	}))
+
+	expvar.Publish("pool", expvar.Func(func() interface{} {
+		return map[string]interface{}{
+			"size":     gw.Pool.Size(),
+			"workers":  gw.Pool.Workers(),
+			"queued":   gw.Pool.Queued(),
+			"timeouts": gw.Pool.Timeouts(),
+		}
+	}))
+	expvar.Publish("backend_pool", expvar.Func(func() interface{} {
+		return map[string]interface{}{
+			"size":     gw.BackendPool.Size(),
+			"workers":  gw.BackendPool.Workers(),
+			"queued":   gw.BackendPool.Queued(),
+			"timeouts": gw.BackendPool.Timeouts(),
+		}
+	}))
+
+	expvar.Publish("origins", expvar.Func(func() interface{} {
+		stats := map[string]interface{}{}
+		for origin, limiter := range limiters {
+			stats[origin] = map[string]interface{}{
+				"in_flight": limiter.InFlight(),
+				"queued":    limiter.Queued(),
+				"rejected":  limiter.Rejected(),
+			}
+		}
+
+		return stats
+	}))
+}
@@ -5,6 +5,8 @@ package gopool
 import (
 	"fmt"
 	"time"
+
+	"go.uber.org/atomic"
 )
 
 // ErrScheduleTimeout returned by Pool to indicate that there no free
@@ -15,6 +17,8 @@ var ErrScheduleTimeout = fmt.Errorf("schedule error: timed out")
 type Pool struct {
 	sem  chan struct{}
 	work chan func()
+
+	timeouts atomic.Uint64
 }
 
 // NewPool creates new goroutine pool with given size. It also creates a work
@@ -53,6 +57,7 @@ func (p *Pool) ScheduleTimeout(timeout time.Duration, task func()) error {
 func (p *Pool) schedule(task func(), timeout <-chan time.Time) error {
 	select {
 	case <-timeout:
+		p.timeouts.Inc()
 		return ErrScheduleTimeout
 	case p.work <- task:
 		return nil
@@ -71,3 +76,23 @@ func (p *Pool) worker(task func()) {
 		task()
 	}
 }
+
+// Size returns the largest number of workers.
+func (p *Pool) Size() int {
+	return cap(p.sem)
+}
+
+// Workers returns the number of running workers.
+func (p *Pool) Workers() int {
+	return len(p.sem)
+}
+
+// Queued returns the number of tasks waiting for a worker.
+func (p *Pool) Queued() int {
+	return len(p.work)
+}
+
+// Timeouts returns the number of tasks ScheduleTimeout gave up on.
+func (p *Pool) Timeouts() uint64 {
+	return p.timeouts.Load()
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ssttevee/go-wsproxy/gateway"
+	"github.com/ssttevee/go-wsproxy/grip"
+)
+
+// routeSpec is a route given on the command line in the form
+// prefix=origin;option=value;...
+type routeSpec struct {
+	prefix  string
+	origin  string
+	options map[string]string
+}
+
+type routesFlag []*routeSpec
+
+func (f *routesFlag) String() string {
+	return ""
+}
+
+func (f *routesFlag) Set(s string) error {
+	parts := strings.Split(s, ";")
+
+	pos := strings.IndexByte(parts[0], '=')
+	if pos < 1 {
+		return fmt.Errorf("expected prefix=origin but got %q", parts[0])
+	}
+
+	spec := &routeSpec{
+		prefix:  parts[0][:pos],
+		origin:  parts[0][pos+1:],
+		options: map[string]string{},
+	}
+
+	for _, part := range parts[1:] {
+		pos := strings.IndexByte(part, '=')
+		if pos < 1 {
+			return fmt.Errorf("expected option=value but got %q", part)
+		}
+
+		spec.options[part[:pos]] = part[pos+1:]
+	}
+
+	*f = append(*f, spec)
+	return nil
+}
+
+func (spec *routeSpec) route(signer *grip.Signer) (*gateway.Route, error) {
+	codec := spec.string("codec", *defaultCodec)
+
+	transport, err := newTransport(spec.origin, codec, signer)
+	if err != nil {
+		return nil, err
+	}
+
+	if transport.Streaming, err = spec.bool("streaming", *streaming); err != nil {
+		return nil, err
+	}
+
+	r := &gateway.Route{
+		Prefix:    spec.prefix,
+		Transport: transport,
+	}
+
+	if r.EventLinger, err = spec.duration("linger", *eventLinger); err != nil {
+		return nil, err
+	}
+
+	if r.MaxEventBatch, err = spec.int("batch_events", *maxEventBatch); err != nil {
+		return nil, err
+	}
+
+	if r.MaxEventBatchBytes, err = spec.int("batch_bytes", *maxEventBatchBytes); err != nil {
+		return nil, err
+	}
+
+	if r.PingInterval, err = spec.duration("ping_interval", *pingInterval); err != nil {
+		return nil, err
+	}
+
+	if r.MaxMissedPongs, err = spec.int("max_missed_pongs", *maxMissedPongs); err != nil {
+		return nil, err
+	}
+
+	if r.IdleTimeout, err = spec.duration("idle_timeout", *idleTimeout); err != nil {
+		return nil, err
+	}
+
+	if r.MaxLifetime, err = spec.duration("max_lifetime", *maxLifetime); err != nil {
+		return nil, err
+	}
+
+	if r.LifetimeJitter, err = spec.duration("lifetime_jitter", *lifetimeJitter); err != nil {
+		return nil, err
+	}
+
+	if r.MaxQueuedMessages, err = spec.int("max_queued", *maxQueuedMessages); err != nil {
+		return nil, err
+	}
+
+	if r.MaxQueuedBytes, err = spec.int("max_queued_bytes", *maxQueuedBytes); err != nil {
+		return nil, err
+	}
+
+	slow := spec.string("slow_consumer", *slowConsumerPolicy)
+	if policy, ok := gateway.ParseSlowConsumerPolicy(slow); ok {
+		r.SlowConsumerPolicy = policy
+	} else {
+		return nil, fmt.Errorf("route %s: unknown slow consumer policy %q", spec.prefix, slow)
+	}
+
+	ping := spec.string("ping", *pingPolicy)
+	if policy, ok := gateway.ParsePingPolicy(ping); ok {
+		r.PingPolicy = policy
+	} else {
+		return nil, fmt.Errorf("route %s: unknown ping policy %q", spec.prefix, ping)
+	}
+
+	return r, nil
+}
+
+func (spec *routeSpec) string(name string, def string) string {
+	if v, ok := spec.options[name]; ok {
+		return v
+	}
+
+	return def
+}
+
+func (spec *routeSpec) duration(name string, def time.Duration) (time.Duration, error) {
+	v, ok := spec.options[name]
+	if !ok {
+		return def, nil
+	}
+
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, fmt.Errorf("route %s: invalid %s: %v", spec.prefix, name, err)
+	}
+
+	return d, nil
+}
+
+func (spec *routeSpec) bool(name string, def bool) (bool, error) {
+	v, ok := spec.options[name]
+	if !ok {
+		return def, nil
+	}
+
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("route %s: invalid %s: %v", spec.prefix, name, err)
+	}
+
+	return b, nil
+}
+
+func (spec *routeSpec) int(name string, def int) (int, error) {
+	v, ok := spec.options[name]
+	if !ok {
+		return def, nil
+	}
+
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("route %s: invalid %s: %v", spec.prefix, name, err)
+	}
+
+	return n, nil
+}
+
+// limiters holds the request limiter of each origin, so that routes to the
+// same origin share it.
+var limiters = map[string]*grip.Limiter{}
+
+// budget is the memory budget shared by the gateway and all transports, nil
+// when there is no limit.
+var budget *grip.Budget
+
+func newTransport(origin string, codec string, signer *grip.Signer) (*grip.HTTPTransport, error) {
+	transport, err := grip.NewHTTPTransport(origin, signer)
+	if err != nil {
+		return nil, err
+	}
+
+	transport.MaxEventSize = *maxEventSize
+	transport.MaxResponseSize = *maxResponseSize
+	transport.CompressThreshold = *compressThreshold
+	transport.BatchWindow = *batchWindow
+	transport.MaxBatchSize = *maxBatchSize
+	transport.Budget = budget
+
+	if *originMaxInFlight > 0 {
+		limiter, ok := limiters[origin]
+		if !ok {
+			limiter = grip.NewLimiter(*originMaxInFlight, *originMaxQueue)
+			limiters[origin] = limiter
+		}
+
+		transport.Limiter = limiter
+	}
+
+	switch codec {
+	case "events":
+		transport.Codec = grip.EventsCodec
+	case "json":
+		transport.Codec = grip.JSONCodec
+	default:
+		return nil, fmt.Errorf("unknown codec %q", codec)
+	}
+
+	return transport, nil
+}